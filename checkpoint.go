@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const CheckpointVersion = 1
+
+// How often scraped conditions are saved to the checkpoint
+const CheckpointInterval = 10 * time.Second
+
+// Progress of a scrape run, so an interrupted run can resume. Conditions
+// holds the Terraform of scraped conditions in policies not yet complete.
+type Checkpoint struct {
+	Version    int            `json:"version"`
+	AccountId  int            `json:"accountId"`
+	Hash       string         `json:"conditionSetHash"`
+	Updated    time.Time      `json:"updated"`
+	Completed  []int          `json:"completedPolicies"`
+	Conditions map[int]string `json:"conditions"`
+	SelfCheck  string         `json:"selfCheckedProfile,omitempty"`
+	filename   string
+	done       map[int]bool
+	dirty      bool
+	lastWrite  time.Time
+}
+
+func (data *LocalData) checkpointFile() string {
+	return fmt.Sprintf("checkpoint_%d.json", data.AccountId)
+}
+
+// Hash of every policy and condition in the account, to tell whether a
+// checkpoint still matches the alerts it was taken from
+func (data *LocalData) conditionSetHash() string {
+	var policyIds []int
+	for policyId := range data.PolicyMap {
+		policyIds = append(policyIds, policyId)
+	}
+	sort.Ints(policyIds)
+	h := sha256.New()
+	for _, policyId := range policyIds {
+		conditionIds := append([]int(nil), data.PolicyMap[policyId].ConditionIds...)
+		sort.Ints(conditionIds)
+		for _, conditionId := range conditionIds {
+			fmt.Fprintf(h, "%d:%d:%s\n", policyId, conditionId, data.ConditionMap[conditionId].Guid)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (data *LocalData) newCheckpoint() {
+	data.Checkpoint = &Checkpoint{
+		Version:    CheckpointVersion,
+		AccountId:  data.AccountId,
+		Hash:       data.conditionSetHash(),
+		Completed:  []int{},
+		Conditions: make(map[int]string),
+		filename:   data.checkpointFile(),
+		done:       make(map[int]bool),
+		dirty:      true,
+	}
+}
+
+// Reload the checkpoint of an earlier run, refusing it when the account's
+// conditions have changed since
+func (data *LocalData) loadCheckpoint() (err error) {
+	filename := data.checkpointFile()
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal(b, checkpoint); err != nil {
+		return fmt.Errorf("invalid %s: %v", filename, err)
+	}
+	if checkpoint.Version != CheckpointVersion {
+		return fmt.Errorf("%s has version %d, expected %d", filename, checkpoint.Version, CheckpointVersion)
+	}
+	if checkpoint.AccountId != data.AccountId {
+		return fmt.Errorf("%s is for account %d", filename, checkpoint.AccountId)
+	}
+	if checkpoint.Hash != data.conditionSetHash() {
+		return fmt.Errorf("%s is stale, the account's conditions changed since it was written; delete it to start over", filename)
+	}
+	if checkpoint.Conditions == nil {
+		checkpoint.Conditions = make(map[int]string)
+	}
+	checkpoint.filename = filename
+	checkpoint.done = make(map[int]bool)
+	for _, policyId := range checkpoint.Completed {
+		checkpoint.done[policyId] = true
+	}
+	log.Printf("Resuming from %s: %d policies complete, %d conditions scraped in others",
+		filename, len(checkpoint.Completed), len(checkpoint.Conditions))
+	data.Checkpoint = checkpoint
+	return nil
+}
+
+func (checkpoint *Checkpoint) completed(policyId int) bool {
+	return checkpoint != nil && checkpoint.done[policyId]
+}
+
+func (checkpoint *Checkpoint) text(conditionId int) (text string, ok bool) {
+	if checkpoint == nil {
+		return
+	}
+	text, ok = checkpoint.Conditions[conditionId]
+	return
+}
+
+func (checkpoint *Checkpoint) addCondition(conditionId int, text string) {
+	if checkpoint == nil {
+		return
+	}
+	checkpoint.Conditions[conditionId] = text
+	checkpoint.dirty = true
+}
+
+// Whether the self-check already passed with this selector profile
+func (checkpoint *Checkpoint) selfChecked(profileHash string) bool {
+	return checkpoint != nil && checkpoint.SelfCheck == profileHash
+}
+
+// Record a passed self-check, so a resumed run can skip it
+func (checkpoint *Checkpoint) passSelfCheck(profileHash string) {
+	if checkpoint == nil {
+		return
+	}
+	checkpoint.SelfCheck = profileHash
+	checkpoint.dirty = true
+	checkpoint.save(true)
+}
+
+// Mark a policy whose file was written with all of its conditions, dropping
+// their Terraform from the checkpoint
+func (checkpoint *Checkpoint) completePolicy(policyId int, conditionIds []int) {
+	if checkpoint == nil || checkpoint.done[policyId] {
+		return
+	}
+	checkpoint.done[policyId] = true
+	checkpoint.Completed = append(checkpoint.Completed, policyId)
+	for _, conditionId := range conditionIds {
+		delete(checkpoint.Conditions, conditionId)
+	}
+	checkpoint.dirty = true
+}
+
+// Write the checkpoint if it changed, at most every CheckpointInterval
+// unless forced. The file is replaced atomically so a crash never leaves
+// it half written.
+func (checkpoint *Checkpoint) save(force bool) {
+	if checkpoint == nil || !checkpoint.dirty {
+		return
+	}
+	if !force && time.Since(checkpoint.lastWrite) < CheckpointInterval {
+		return
+	}
+	checkpoint.Updated = time.Now().UTC()
+	b, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		log.Printf("Error creating %s: %v", checkpoint.filename, err)
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(checkpoint.filename), filepath.Base(checkpoint.filename)+".*.tmp")
+	if err != nil {
+		log.Printf("Error writing %s: %v", checkpoint.filename, err)
+		return
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), checkpoint.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("Error writing %s: %v", checkpoint.filename, err)
+		return
+	}
+	checkpoint.dirty = false
+	checkpoint.lastWrite = time.Now()
+}
+
+// Remove the checkpoint once every policy is complete, otherwise save it for -resume
+func (data *LocalData) finishCheckpoint() {
+	checkpoint := data.Checkpoint
+	if checkpoint == nil {
+		return
+	}
+	for _, policyId := range data.PolicyIds {
+		if !checkpoint.done[policyId] {
+			checkpoint.save(true)
+			log.Printf("Saved progress to %s, continue with -resume", checkpoint.filename)
+			return
+		}
+	}
+	if err := os.Remove(checkpoint.filename); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing %s: %v", checkpoint.filename, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Id and name of the synthetic policy that -include-orphans puts orphaned
+// conditions in
+const (
+	UnassignedPolicyId   = 0
+	UnassignedPolicyName = "unassigned"
+)
+
+// Inconsistencies between the NerdGraph APIs, found while fetching the
+// inventory
+type Consistency struct {
+	Orphans           []Condition         `json:"orphanedConditions"`
+	Malformed         []MalformedEntity   `json:"malformedEntities"`
+	UnindexedPolicies []ConsistencyPolicy `json:"unindexedPolicies"`
+	NotFound          []ConsistencyDetail `json:"detailsNotFound"`
+}
+
+// A condition entity that could not be parsed
+type MalformedEntity struct {
+	Guid  string `json:"guid"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// A policy with no policy entity in the entity index
+type ConsistencyPolicy struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// An NRQL condition whose details query returned Not Found
+type ConsistencyDetail struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	PolicyId string `json:"policyId"`
+	Type     string `json:"type"`
+}
+
+func (consistency *Consistency) malformed(entity Entity, err error) {
+	consistency.Malformed = append(consistency.Malformed, MalformedEntity{
+		Guid:  entity.Guid,
+		Name:  entity.Name,
+		Type:  entity.Type,
+		Error: err.Error(),
+	})
+}
+
+func (consistency *Consistency) problems() int {
+	return len(consistency.Orphans) + len(consistency.Malformed) + len(consistency.UnindexedPolicies) + len(consistency.NotFound)
+}
+
+// Log a one line summary of the problems found while fetching, when there
+// are any. Unindexed policies take an extra query, so are only checked for
+// the report.
+func (consistency *Consistency) summary() {
+	if len(consistency.Orphans)+len(consistency.Malformed)+len(consistency.NotFound) == 0 {
+		return
+	}
+	log.Printf("Consistency: %d orphaned conditions, %d malformed entities, %d conditions without details, see -consistency",
+		len(consistency.Orphans), len(consistency.Malformed), len(consistency.NotFound))
+}
+
+// Put orphaned conditions in a synthetic "unassigned" policy, so they are
+// included in outputs. The report keeps the policy id they had. This is done
+// after the inventory is saved, so the file only has them in the report.
+func (inventory *Inventory) includeOrphans() {
+	var added int
+	policy, ok := inventory.PolicyMap[UnassignedPolicyId]
+	if !ok {
+		policy = Policy{
+			AccountId:          inventory.AccountId,
+			Id:                 strconv.Itoa(UnassignedPolicyId),
+			Name:               UnassignedPolicyName,
+			IncidentPreference: "PER_POLICY",
+		}
+	}
+	for _, condition := range inventory.Consistency.Orphans {
+		id, err := strconv.Atoi(condition.Id)
+		if err != nil {
+			continue
+		}
+		if _, ok := inventory.ConditionMap[id]; ok {
+			continue
+		}
+		condition.PolicyId = policy.Id
+		inventory.ConditionMap[id] = condition
+		policy.ConditionIds = append(policy.ConditionIds, id)
+		added++
+	}
+	if added == 0 {
+		return
+	}
+	sort.Ints(policy.ConditionIds)
+	if !ok {
+		inventory.PolicyIds = append([]int{UnassignedPolicyId}, inventory.PolicyIds...)
+	}
+	inventory.PolicyMap[UnassignedPolicyId] = policy
+	log.Printf("Including %d orphaned conditions in policy %q", added, UnassignedPolicyName)
+}
+
+// The policy id an orphaned condition had, before -include-orphans moved it
+func (consistency *Consistency) orphanPolicyId(conditionId string) string {
+	for _, condition := range consistency.Orphans {
+		if condition.Id == conditionId {
+			return condition.PolicyId
+		}
+	}
+	return ""
+}
+
+// Find policies the entity index does not know, which have no GUID once
+// policy entities have been fetched
+func (data *LocalData) checkPolicyIndex() {
+	data.getPolicyTags()
+	data.Consistency.UnindexedPolicies = nil
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		if len(policy.Guid) == 0 && policyId != UnassignedPolicyId {
+			data.Consistency.UnindexedPolicies = append(data.Consistency.UnindexedPolicies,
+				ConsistencyPolicy{Id: policy.Id, Name: policy.Name})
+		}
+	}
+}
+
+// Print the report as text or JSON
+func (consistency Consistency) print(asJSON bool) {
+	if asJSON {
+		if consistency.Orphans == nil {
+			consistency.Orphans = []Condition{}
+		}
+		if consistency.Malformed == nil {
+			consistency.Malformed = []MalformedEntity{}
+		}
+		if consistency.UnindexedPolicies == nil {
+			consistency.UnindexedPolicies = []ConsistencyPolicy{}
+		}
+		if consistency.NotFound == nil {
+			consistency.NotFound = []ConsistencyDetail{}
+		}
+		b, _ := json.MarshalIndent(consistency, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Printf("Consistency: %d orphaned conditions, %d malformed entities, %d unindexed policies, %d details not found\n",
+		len(consistency.Orphans), len(consistency.Malformed), len(consistency.UnindexedPolicies), len(consistency.NotFound))
+	for _, condition := range consistency.Orphans {
+		fmt.Printf("ORPHAN condition %s %q (%s), policy %s was not returned by the policies search\n",
+			condition.Id, condition.Name, condition.Type, condition.PolicyId)
+	}
+	for _, entity := range consistency.Malformed {
+		fmt.Printf("MALFORMED %s %q %s: %s\n", entity.Type, entity.Name, entity.Guid, entity.Error)
+	}
+	for _, policy := range consistency.UnindexedPolicies {
+		fmt.Printf("UNINDEXED policy %s %q has no policy entity\n", policy.Id, policy.Name)
+	}
+	for _, detail := range consistency.NotFound {
+		fmt.Printf("NOTFOUND condition %s %q (%s) in policy %s has no NRQL condition details\n",
+			detail.Id, detail.Name, detail.Type, detail.PolicyId)
+	}
+}
+
+// Report inconsistencies, with exit status 2 when any were found
+func (data *LocalData) reportConsistency() int {
+	data.checkPolicyIndex()
+	data.Consistency.print(data.JSONOutput)
+	if data.Consistency.problems() > 0 {
+		return 2
+	}
+	return 0
+}
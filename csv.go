@@ -5,48 +5,174 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-func (data *LocalData) writeCSV() {
+// The columns written when -columns is not set
+const DefaultColumns = "conditionId,conditionName,policyId,policyName,entityGuid,nrqlQuery,type,enabled"
+
+// Link to an entity in the NR1 UI
+const PermalinkURL = "https://one.newrelic.com/redirect/entity/"
+
+// A CSV column, with how to get its value for a condition
+type CSVColumn struct {
+	Name        string
+	Description string
+	Value       func(policy Policy, condition Condition) string
+}
+
+// Every column that can be selected, in the order -list-columns shows them
+var CSVColumns = []CSVColumn{
+	{"conditionId", "Condition id", func(p Policy, c Condition) string { return c.Id }},
+	{"conditionName", "Condition name", func(p Policy, c Condition) string { return c.Name }},
+	{"policyId", "Policy id", func(p Policy, c Condition) string { return p.Id }},
+	{"policyName", "Policy name", func(p Policy, c Condition) string { return p.Name }},
+	{"entityGuid", "Condition entity GUID", func(p Policy, c Condition) string { return c.Guid }},
+	{"nrqlQuery", "NRQL query", func(p Policy, c Condition) string { return c.Query }},
+	{"type", "Condition type", func(p Policy, c Condition) string { return c.Type }},
+	{"enabled", "Whether the condition is enabled", func(p Policy, c Condition) string { return strconv.FormatBool(c.Enabled) }},
+	{"description", "Condition description", func(p Policy, c Condition) string { return c.Description }},
+	{"runbookUrl", "Runbook URL", func(p Policy, c Condition) string { return c.RunbookURL }},
+	{"criticalThreshold", "Critical operator and threshold, e.g. ABOVE 90", func(p Policy, c Condition) string { return thresholdText(c.Critical) }},
+	{"criticalDuration", "Seconds the critical threshold must be breached", func(p Policy, c Condition) string { return durationText(c.Critical) }},
+	{"criticalOccurrences", "ALL or AT_LEAST_ONCE for the critical threshold", func(p Policy, c Condition) string { return c.Critical.ThresholdOccurrences }},
+	{"warningThreshold", "Warning operator and threshold", func(p Policy, c Condition) string { return thresholdText(c.Warning) }},
+	{"warningDuration", "Seconds the warning threshold must be breached", func(p Policy, c Condition) string { return durationText(c.Warning) }},
+	{"warningOccurrences", "ALL or AT_LEAST_ONCE for the warning threshold", func(p Policy, c Condition) string { return c.Warning.ThresholdOccurrences }},
+	{"aggregationWindow", "Aggregation window in seconds", func(p Policy, c Condition) string { return intText(c.AggWindow) }},
+	{"fillOption", "Gap filling option", func(p Policy, c Condition) string { return c.Fill }},
+	{"fillValue", "Gap filling value, for the STATIC fill option", func(p Policy, c Condition) string { return floatText(c.FillValue) }},
+	{"expirationDuration", "Seconds without a signal before it is lost", func(p Policy, c Condition) string {
+		if c.Expiration.ExpirationDuration == nil {
+			return ""
+		}
+		return strconv.Itoa(*c.Expiration.ExpirationDuration)
+	}},
+	{"openViolationOnExpiration", "Whether losing the signal opens a violation", func(p Policy, c Condition) string {
+		return strconv.FormatBool(c.Expiration.OpenViolationOnExpiration)
+	}},
+	{"closeViolationsOnExpiration", "Whether losing the signal closes open violations", func(p Policy, c Condition) string {
+		return strconv.FormatBool(c.Expiration.CloseViolationsOnExpiration)
+	}},
+	{"incidentPreference", "Policy incident preference", func(p Policy, c Condition) string { return p.IncidentPreference }},
+	{"policyTags", "Policy tags, as key=value pairs separated by ;", func(p Policy, c Condition) string { return tagsText(p.Tags) }},
+	{"permalink", "Link to the condition in the NR1 UI", func(p Policy, c Condition) string { return PermalinkURL + c.Guid }},
+}
+
+func thresholdText(t Threshold) string {
+	if len(t.Operator) == 0 {
+		return ""
+	}
+	return t.Operator + " " + strconv.FormatFloat(t.Threshold, 'f', -1, 64)
+}
+func durationText(t Threshold) string {
+	if len(t.Operator) == 0 {
+		return ""
+	}
+	return strconv.Itoa(t.ThresholdDuration)
+}
+func intText(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+func floatText(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+// Tags sorted by key, with multiple values joined by |
+func tagsText(tags map[string][]string) string {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+strings.Join(tags[key], "|"))
+	}
+	return strings.Join(pairs, ";")
+}
+
+// Print the available columns for -list-columns
+func listColumns() {
+	for _, column := range CSVColumns {
+		fmt.Printf("%-28s %s\n", column.Name, column.Description)
+	}
+	fmt.Printf("\nDefault: %s\n", DefaultColumns)
+}
+
+// Look up the comma separated column names chosen with -columns, in order
+func (data *LocalData) selectColumns() error {
+	names := data.ColumnNames
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+	data.Columns = nil
+	for _, name := range strings.Split(names, ",") {
+		column, ok := csvColumn(strings.TrimSpace(name))
+		if !ok {
+			return fmt.Errorf("unknown column %q, see -list-columns", strings.TrimSpace(name))
+		}
+		data.Columns = append(data.Columns, column)
+	}
+	return nil
+}
+
+func csvColumn(name string) (CSVColumn, bool) {
+	for _, column := range CSVColumns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return CSVColumn{}, false
+}
+
+func (data *LocalData) hasColumn(name string) bool {
+	for _, column := range data.Columns {
+		if column.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (inventory *Inventory) writeCSV(columns []CSVColumn) {
 	var rows [][]string
 
-	outputCSV := fmt.Sprintf("alerts_%d.csv", data.AccountId)
+	outputCSV := fmt.Sprintf("alerts_%d.csv", inventory.AccountId)
 	f, err := os.Create(outputCSV)
 	if err != nil {
 		log.Printf("Error opening csv: %v", err)
 	}
 
 	// Make rows
-	rows = append(rows, []string{
-		"conditionId",
-		"conditionName",
-		"policyId",
-		"policyName",
-		"entityGuid",
-		"nrqlQuery",
-		"type",
-		"enabled",
-	})
-	for _, policyId := range data.PolicyIds {
-		policy, ok := data.PolicyMap[policyId]
+	var header []string
+	for _, column := range columns {
+		header = append(header, column.Name)
+	}
+	rows = append(rows, header)
+	for _, policyId := range inventory.PolicyIds {
+		policy, ok := inventory.PolicyMap[policyId]
 		if !ok {
 			continue
 		}
 		for _, conditionId := range policy.ConditionIds {
-			condition, ok := data.ConditionMap[conditionId]
+			condition, ok := inventory.ConditionMap[conditionId]
 			if !ok {
 				continue
 			}
-			rows = append(rows, []string{
-				condition.Id,
-				condition.Name,
-				policy.Id,
-				policy.Name,
-				condition.Guid,
-				condition.Query,
-				condition.Type,
-				fmt.Sprintf("%t", condition.Enabled),
-			})
+			var row []string
+			for _, column := range columns {
+				row = append(row, column.Value(policy, condition))
+			}
+			rows = append(rows, row)
 		}
 	}
 	log.Printf("Writing csv %s", outputCSV)
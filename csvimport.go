@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Columns that can be edited in a CSV and applied back to New Relic. Every
+// other column is read-only and must still match the live condition.
+var EditableColumns = map[string]bool{"conditionName": true, "enabled": true, "nrqlQuery": true}
+
+// Fields to change with an NRQL condition update mutation
+type ConditionUpdate struct {
+	Name    *string     `json:"name,omitempty"`
+	Enabled *bool       `json:"enabled,omitempty"`
+	Nrql    *UpdateNrql `json:"nrql,omitempty"`
+}
+type UpdateNrql struct {
+	Query string `json:"query"`
+}
+
+// The edits to one condition, from one CSV row
+type ImportChange struct {
+	Line        int
+	ConditionId int
+	Update      ConditionUpdate
+	Changes     []string
+}
+
+// Compare each row of an edited CSV with the live condition. Rows with
+// unknown ids, bad values or edits to read-only columns are all reported,
+// and nothing is planned if there are any.
+func (data *LocalData) planImport(filename string) (changes []ImportChange, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header of %s: %v", filename, err)
+	}
+	var columns []CSVColumn
+	idIndex := -1
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		column, ok := csvColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("%s line 1: unknown column %q, see -list-columns", filename, name)
+		}
+		if name == "conditionId" {
+			idIndex = i
+		}
+		if name == "policyTags" {
+			data.getPolicyTags()
+		}
+		columns = append(columns, column)
+	}
+	if idIndex < 0 {
+		return nil, fmt.Errorf("%s has no conditionId column", filename)
+	}
+
+	var problems []string
+	seen := make(map[int]int)
+	for {
+		var row []string
+		row, err = r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", filename, err)
+		}
+		line, _ := r.FieldPos(0)
+		problem := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+		}
+
+		id, err := strconv.Atoi(strings.TrimSpace(row[idIndex]))
+		if err != nil {
+			problem("invalid conditionId %q", row[idIndex])
+			continue
+		}
+		condition, ok := data.ConditionMap[id]
+		if !ok {
+			problem("unknown condition %d", id)
+			continue
+		}
+		if previous, ok := seen[id]; ok {
+			problem("condition %d is also on line %d", id, previous)
+			continue
+		}
+		seen[id] = line
+		policyId, _ := strconv.Atoi(condition.PolicyId)
+		policy := data.PolicyMap[policyId]
+
+		change := ImportChange{Line: line, ConditionId: id}
+		for i, column := range columns {
+			value := strings.TrimSpace(row[i])
+			live := column.Value(policy, condition)
+			if value == strings.TrimSpace(live) {
+				continue
+			}
+			if !EditableColumns[column.Name] {
+				// Spreadsheets often change the case of values such as TRUE
+				if strings.EqualFold(value, strings.TrimSpace(live)) {
+					continue
+				}
+				problem("column %s is read-only, live value %q was changed to %q", column.Name, live, value)
+				continue
+			}
+			switch column.Name {
+			case "conditionName":
+				if len(value) == 0 {
+					problem("conditionName cannot be empty")
+					continue
+				}
+				change.Update.Name = &value
+			case "enabled":
+				enabled, err := strconv.ParseBool(value)
+				if err != nil {
+					problem("enabled must be true or false, not %q", value)
+					continue
+				}
+				if enabled == condition.Enabled {
+					continue
+				}
+				change.Update.Enabled = &enabled
+				value = strconv.FormatBool(enabled)
+			case "nrqlQuery":
+				if len(value) == 0 {
+					problem("nrqlQuery cannot be empty")
+					continue
+				}
+				change.Update.Nrql = &UpdateNrql{Query: value}
+			}
+			change.Changes = append(change.Changes, fmt.Sprintf("%s: %q -> %q", column.Name, live, value))
+		}
+		if len(change.Changes) == 0 {
+			continue
+		}
+		if condition.NrqlType != "STATIC" && condition.NrqlType != "BASELINE" {
+			problem("condition %d is %s, only static and baseline NRQL conditions can be updated", id, condition.Type)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			log.Printf("%s %s", filename, problem)
+		}
+		return nil, fmt.Errorf("%d problems in %s, nothing was changed", len(problems), filename)
+	}
+	return changes, nil
+}
+
+// Send the update mutation for each change, and keep the results
+func (data *LocalData) applyImport(changes []ImportChange) (failed int) {
+	client := &http.Client{}
+	for _, change := range changes {
+		condition := data.ConditionMap[change.ConditionId]
+		var gQuery GraphQlPayload
+		gQuery.Query = UpdateSQuery
+		if condition.NrqlType == "BASELINE" {
+			gQuery.Query = UpdateBQuery
+		}
+		gQuery.Variables.AccountId = data.AccountId
+		gQuery.Variables.ConditionId = condition.Id
+		gQuery.Variables.Condition = &change.Update
+		j, err := json.Marshal(gQuery)
+		if err != nil {
+			log.Printf("Error creating GraphQl condition.id %s update mutation: %v", condition.Id, err)
+			failed++
+			continue
+		}
+		b := retryQuery(client, "POST", GraphQlEndpoint, string(j), data.GraphQlHeaders)
+		var graphQlResult GraphQlResult
+		err = json.Unmarshal(b, &graphQlResult)
+		if err != nil {
+			log.Printf("Error parsing GraphQl condition.id %s update mutation result: %v", condition.Id, err)
+			failed++
+			continue
+		}
+		if len(graphQlResult.Errors) > 0 {
+			log.Printf("Errors with GraphQl condition.id %s update mutation (line %d): %v", condition.Id, change.Line, graphQlResult.Errors)
+			failed++
+			continue
+		}
+		updated := graphQlResult.Data.UpdateS
+		if condition.NrqlType == "BASELINE" {
+			updated = graphQlResult.Data.UpdateB
+		}
+		condition.Name = updated.Name
+		condition.Enabled = updated.Enabled
+		condition.Query = updated.Nrql.Query
+		data.ConditionMap[change.ConditionId] = condition
+		log.Printf("Updated condition %s %q", condition.Id, condition.Name)
+	}
+	return
+}
+
+// Plan the edits in a CSV, and apply them with -apply
+func (data *LocalData) importCSV() (err error) {
+	changes, err := data.planImport(data.ImportFile)
+	if err != nil {
+		return
+	}
+	for _, change := range changes {
+		condition := data.ConditionMap[change.ConditionId]
+		fmt.Printf("UPDATE condition %s %q (line %d)\n", condition.Id, condition.Name, change.Line)
+		for _, text := range change.Changes {
+			fmt.Printf("  %s\n", text)
+		}
+	}
+	fmt.Printf("Import of %s: %d conditions to update\n", data.ImportFile, len(changes))
+	if !data.Apply || len(changes) == 0 {
+		if len(changes) > 0 {
+			fmt.Println("Run again with -apply to make these changes")
+		}
+		return
+	}
+	if failed := data.applyImport(changes); failed > 0 {
+		return fmt.Errorf("%d of %d updates failed", failed, len(changes))
+	}
+	log.Printf("Applied %d condition updates", len(changes))
+	return
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanImport(t *testing.T) {
+	data := &LocalData{Inventory: Inventory{
+		PolicyMap: map[int]Policy{1: {Id: "1", Name: "Policy", ConditionIds: []int{11, 12}}},
+		ConditionMap: map[int]Condition{
+			11: {PolicyId: "1", Id: "11", Name: "CPU", Type: "NRQL", NrqlType: "STATIC", Enabled: true, Query: "SELECT 1"},
+			12: {PolicyId: "1", Id: "12", Name: "Apdex", Type: "APM", Enabled: true},
+		},
+	}}
+	header := "conditionId,conditionName,policyName,enabled,nrqlQuery\n"
+	filename := filepath.Join(t.TempDir(), "import.csv")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range []struct {
+		name    string
+		rows    string
+		changes int
+		problem string
+	}{
+		{"unchanged", "11,CPU,Policy,true,SELECT 1\n", 0, ""},
+		{"edits", "11,CPU high,POLICY,FALSE,SELECT 2\n", 1, ""},
+		{"same enabled", "11,CPU,Policy,TRUE,SELECT 1\n", 0, ""},
+		{"invalid id", "x,CPU,Policy,true,SELECT 1\n", 0, `line 2: invalid conditionId "x"`},
+		{"unknown id", "99,CPU,Policy,true,SELECT 1\n", 0, "line 2: unknown condition 99"},
+		{"duplicate", "11,CPU,Policy,true,SELECT 1\n11,CPU high,Policy,true,SELECT 1\n", 0, "line 3: condition 11 is also on line 2"},
+		{"read-only", "11,CPU,Policy,true,SELECT 1\n12,Apdex,Other,true,\n", 0, `line 3: column policyName is read-only, live value "Policy" was changed to "Other"`},
+		{"bad bool", "11,CPU,Policy,yes,SELECT 1\n", 0, `line 2: enabled must be true or false, not "yes"`},
+		{"empty name", "11,,Policy,true,SELECT 1\n", 0, "line 2: conditionName cannot be empty"},
+		{"not NRQL", "12,Apdex low,Policy,true,\n", 0, "line 2: condition 12 is APM, only static and baseline NRQL conditions can be updated"},
+	} {
+		if err := os.WriteFile(filename, []byte(header+test.rows), 0644); err != nil {
+			t.Fatal(err)
+		}
+		logged.Reset()
+		changes, err := data.planImport(filename)
+		if len(test.problem) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if len(changes) != test.changes {
+				t.Errorf("%s: %d changes, want %d", test.name, len(changes), test.changes)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no error, want %q", test.name, test.problem)
+			continue
+		}
+		if changes != nil {
+			t.Errorf("%s: planned %d changes despite problems", test.name, len(changes))
+		}
+		if !strings.Contains(logged.String(), test.problem) {
+			t.Errorf("%s: logged %q, want %q", test.name, logged.String(), test.problem)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Save a full page screenshot and the DOM of a failed condition scrape
+func (data *LocalData) saveDiagnostics(ctx context.Context, condition Condition, scrapeErr error) {
+	dir := filepath.Join(data.DiagnosticsDir, condition.Id)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("Error creating diagnostics folder: %v", err)
+		return
+	}
+	log.Printf("Saving diagnostics for condition %s to %s", condition.Id, dir)
+	err = os.WriteFile(filepath.Join(dir, "error.txt"), []byte(scrapeErr.Error()+"\n"), 0644)
+	if err != nil {
+		log.Printf("Error writing diagnostics: %v", err)
+	}
+
+	var screenshot []byte
+	var dom string
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err = chromedp.Run(ctx,
+		chromedp.FullScreenshot(&screenshot, 100),
+		chromedp.OuterHTML("html", &dom, chromedp.ByQuery),
+	)
+	if err != nil {
+		log.Printf("Error capturing diagnostics for condition %s: %v", condition.Id, err)
+	}
+	if len(screenshot) > 0 {
+		err = os.WriteFile(filepath.Join(dir, "screenshot.png"), screenshot, 0644)
+		if err != nil {
+			log.Printf("Error writing diagnostics: %v", err)
+		}
+	}
+	if len(dom) > 0 {
+		err = os.WriteFile(filepath.Join(dir, "dom.html"), []byte(dom), 0644)
+		if err != nil {
+			log.Printf("Error writing diagnostics: %v", err)
+		}
+	}
+}
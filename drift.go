@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Field level differences between Terraform and the live configuration
+type DriftField struct {
+	Field     string `json:"field"`
+	Terraform string `json:"terraform"`
+	Live      string `json:"live"`
+}
+type DriftEntry struct {
+	Kind    string       `json:"kind"`
+	Id      string       `json:"id"`
+	Name    string       `json:"name"`
+	Address string       `json:"address"`
+	File    string       `json:"file"`
+	Fields  []DriftField `json:"fields"`
+}
+type DriftReport struct {
+	Directory  string       `json:"directory"`
+	Checked    int          `json:"checked"`
+	Unmatched  []string     `json:"unmatched"`
+	Unresolved []string     `json:"unresolved"`
+	Drifted    []DriftEntry `json:"drifted"`
+}
+
+// Compares one Terraform attribute with its live value
+type driftCheck struct {
+	field string
+	expr  string
+	live  string
+	equal func(tf, live string) bool
+}
+
+func sameText(tf, live string) bool {
+	return tf == live
+}
+func sameFold(tf, live string) bool {
+	return strings.EqualFold(tf, live)
+}
+func sameQuery(tf, live string) bool {
+	return strings.Join(strings.Fields(tf), " ") == strings.Join(strings.Fields(live), " ")
+}
+
+// Threshold occurrences, which the older time_function attribute spells "any"
+// instead of AT_LEAST_ONCE
+func sameOccurrences(tf, live string) bool {
+	return normalOccurrences(tf) == normalOccurrences(live)
+}
+func normalOccurrences(value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "ANY" {
+		return "AT_LEAST_ONCE"
+	}
+	return value
+}
+func sameNumber(tf, live string) bool {
+	a, errA := strconv.ParseFloat(tf, 64)
+	b, errB := strconv.ParseFloat(live, 64)
+	return errA == nil && errB == nil && a == b
+}
+
+// Run the checks for one resource, skipping attributes Terraform leaves unset
+func (report *DriftReport) compare(config *TFConfig, r *TFResource, entry DriftEntry, checks []driftCheck) {
+	report.Checked++
+	for _, check := range checks {
+		if len(strings.TrimSpace(check.expr)) == 0 {
+			continue
+		}
+		value, ok := config.resolve(check.expr)
+		if !ok {
+			report.Unresolved = append(report.Unresolved, fmt.Sprintf("%s.%s = %s", r.Address(), check.field, check.expr))
+			continue
+		}
+		if !check.equal(value, check.live) {
+			entry.Fields = append(entry.Fields, DriftField{Field: check.field, Terraform: value, Live: check.live})
+		}
+	}
+	if len(entry.Fields) > 0 {
+		report.Drifted = append(report.Drifted, entry)
+	}
+}
+
+// Threshold blocks, either critical/warning or the older term blocks
+func thresholdBlock(config *TFConfig, r *TFResource, priority string) *TFItem {
+	if block := r.Item.Block(priority); block != nil {
+		return block
+	}
+	for _, item := range r.Item.Body.Items {
+		if item.Body == nil || item.Key != "term" {
+			continue
+		}
+		value, _ := config.resolve(item.Attr("priority"))
+		if strings.EqualFold(value, priority) || len(value) == 0 && priority == "critical" {
+			return item
+		}
+	}
+	return nil
+}
+
+func thresholdChecks(config *TFConfig, r *TFResource, priority string, live Threshold) (checks []driftCheck) {
+	block := thresholdBlock(config, r, priority)
+	if block == nil {
+		return
+	}
+	duration := block.Attr("threshold_duration")
+	if len(duration) == 0 {
+		duration = block.Attr("duration")
+	}
+	occurrences := block.Attr("threshold_occurrences")
+	if len(occurrences) == 0 {
+		occurrences = block.Attr("time_function")
+	}
+	return []driftCheck{
+		{priority + ".operator", block.Attr("operator"), live.Operator, sameFold},
+		{priority + ".threshold", block.Attr("threshold"), strconv.FormatFloat(live.Threshold, 'f', -1, 64), sameNumber},
+		{priority + ".threshold_duration", duration, strconv.Itoa(live.ThresholdDuration), sameNumber},
+		{priority + ".threshold_occurrences", occurrences, live.ThresholdOccurrences, sameOccurrences},
+	}
+}
+
+// Compare managed policies and conditions with their live configuration
+func (data *LocalData) driftReport(dir string) (report DriftReport, err error) {
+	var config *TFConfig
+	config, err = loadTFDir(dir)
+	if err != nil {
+		return
+	}
+	data.matchTFConfig(config)
+	report.Directory = dir
+
+	for _, r := range config.Policies {
+		if r.LiveId == 0 {
+			report.Unmatched = append(report.Unmatched, r.Address())
+			continue
+		}
+		policy := data.PolicyMap[r.LiveId]
+		report.compare(config, r, DriftEntry{Kind: "policy", Id: policy.Id, Name: policy.Name, Address: r.Address(), File: r.File}, []driftCheck{
+			{"name", r.Item.Attr("name"), policy.Name, sameText},
+			{"incident_preference", r.Item.Attr("incident_preference"), policy.IncidentPreference, sameFold},
+		})
+	}
+	for _, r := range config.Conditions {
+		if r.LiveId == 0 {
+			report.Unmatched = append(report.Unmatched, r.Address())
+			continue
+		}
+		condition := data.ConditionMap[r.LiveId]
+		var query string
+		if nrql := r.Item.Block("nrql"); nrql != nil {
+			query = nrql.Attr("query")
+		}
+		checks := []driftCheck{
+			{"name", r.Item.Attr("name"), condition.Name, sameText},
+			{"enabled", r.Item.Attr("enabled"), strconv.FormatBool(condition.Enabled), sameText},
+			{"nrql.query", query, condition.Query, sameQuery},
+			{"aggregation_window", r.Item.Attr("aggregation_window"), strconv.Itoa(condition.AggWindow), sameNumber},
+			{"fill_option", r.Item.Attr("fill_option"), condition.Fill, sameFold},
+		}
+		checks = append(checks, thresholdChecks(config, r, "critical", condition.Critical)...)
+		checks = append(checks, thresholdChecks(config, r, "warning", condition.Warning)...)
+		report.compare(config, r, DriftEntry{Kind: "condition", Id: condition.Id, Name: condition.Name, Address: r.Address(), File: r.File}, checks)
+	}
+	return
+}
+
+// Print the report as text or JSON
+func (report DriftReport) print(asJSON bool) {
+	if asJSON {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Printf("Terraform in %s: %d resources checked, %d drifted, %d unmatched\n",
+		report.Directory, report.Checked, len(report.Drifted), len(report.Unmatched))
+	for _, entry := range report.Drifted {
+		fmt.Printf("DRIFT %s %s %q %s (%s)\n", entry.Kind, entry.Id, entry.Name, entry.Address, entry.File)
+		for _, field := range entry.Fields {
+			fmt.Printf("  %s: terraform %q, live %q\n", field.Field, field.Terraform, field.Live)
+		}
+	}
+	for _, address := range report.Unmatched {
+		fmt.Printf("UNMATCHED %s\n", address)
+	}
+	for _, expr := range report.Unresolved {
+		fmt.Printf("UNRESOLVED %s\n", expr)
+	}
+}
+
+// Report drift, with exit status 2 when any was found
+func (data *LocalData) reportDrift() int {
+	report, err := data.driftReport(data.DriftDir)
+	if err != nil {
+		log.Printf("Error reading Terraform in %s: %v", data.DriftDir, err)
+		return 1
+	}
+	report.print(data.JSONOutput)
+	if len(report.Drifted) > 0 {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSameOccurrences(t *testing.T) {
+	for _, test := range []struct {
+		tf, live string
+		same     bool
+	}{
+		{"ALL", "ALL", true},
+		{"all", "ALL", true},
+		{"AT_LEAST_ONCE", "AT_LEAST_ONCE", true},
+		{"at_least_once", "AT_LEAST_ONCE", true},
+		{"any", "AT_LEAST_ONCE", true},
+		{" Any ", "AT_LEAST_ONCE", true},
+		{"any", "ALL", false},
+		{"all", "AT_LEAST_ONCE", false},
+		{"AT_LEAST_ONCE", "ALL", false},
+	} {
+		if got := sameOccurrences(test.tf, test.live); got != test.same {
+			t.Errorf("sameOccurrences(%q, %q) = %v, want %v", test.tf, test.live, got, test.same)
+		}
+	}
+}
+
+func TestThresholdChecks(t *testing.T) {
+	body, err := parseTF(`resource "newrelic_nrql_alert_condition" "cpu" {
+  term {
+    operator = "above"
+    threshold = 90
+    duration = 300
+    time_function = "any"
+  }
+  warning {
+    operator = "ABOVE"
+    threshold = 80.0
+    threshold_duration = 600
+    threshold_occurrences = "all"
+  }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &TFConfig{}
+	r := &TFResource{Type: TFConditionType, Name: "cpu", Item: body.Items[0]}
+	for _, test := range []struct {
+		priority string
+		live     Threshold
+		drifted  []string
+	}{
+		{"critical", Threshold{Operator: "ABOVE", Threshold: 90, ThresholdDuration: 300, ThresholdOccurrences: "AT_LEAST_ONCE"}, nil},
+		{"critical", Threshold{Operator: "ABOVE", Threshold: 90, ThresholdDuration: 300, ThresholdOccurrences: "ALL"}, []string{"critical.threshold_occurrences"}},
+		{"warning", Threshold{Operator: "ABOVE", Threshold: 80, ThresholdDuration: 600, ThresholdOccurrences: "ALL"}, nil},
+		{"warning", Threshold{Operator: "BELOW", Threshold: 85, ThresholdDuration: 600, ThresholdOccurrences: "ALL"}, []string{"warning.operator", "warning.threshold"}},
+	} {
+		var report DriftReport
+		report.compare(config, r, DriftEntry{}, thresholdChecks(config, r, test.priority, test.live))
+		var drifted []string
+		for _, entry := range report.Drifted {
+			for _, field := range entry.Fields {
+				drifted = append(drifted, field.Field)
+			}
+		}
+		if len(drifted) != len(test.drifted) {
+			t.Errorf("%s %+v drifted %v, want %v", test.priority, test.live, drifted, test.drifted)
+			continue
+		}
+		for i := range drifted {
+			if drifted[i] != test.drifted[i] {
+				t.Errorf("%s %+v drifted %v, want %v", test.priority, test.live, drifted, test.drifted)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Version of the export schema below. Adding fields keeps the version,
+// renaming, removing or changing the meaning of a field bumps it.
+const ExportSchemaVersion = 1
+
+// JSON export of the whole inventory
+type Export struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	GeneratedAt   time.Time      `json:"generatedAt"`
+	Account       ExportAccount  `json:"account"`
+	Policies      []ExportPolicy `json:"policies"`
+}
+type ExportAccount struct {
+	Id int `json:"id"`
+}
+type ExportPolicy struct {
+	ExportPolicyInfo
+	Conditions []ExportCondition `json:"conditions"`
+}
+type ExportPolicyInfo struct {
+	Id                 string              `json:"id"`
+	Guid               string              `json:"guid,omitempty"`
+	Name               string              `json:"name"`
+	IncidentPreference string              `json:"incidentPreference"`
+	Tags               map[string][]string `json:"tags"`
+}
+type ExportCondition struct {
+	Id                string              `json:"id"`
+	Guid              string              `json:"guid"`
+	Name              string              `json:"name"`
+	PolicyId          string              `json:"policyId"`
+	Type              string              `json:"type"`
+	NrqlType          string              `json:"nrqlType"`
+	Enabled           bool                `json:"enabled"`
+	UpdatedAt         int64               `json:"updatedAt"`
+	Description       string              `json:"description"`
+	RunbookURL        string              `json:"runbookUrl"`
+	Nrql              ExportNrql          `json:"nrql"`
+	BaselineDirection string              `json:"baselineDirection"`
+	Terms             []Term              `json:"terms"`
+	Signal            ExportSignal        `json:"signal"`
+	Expiration        Expiration          `json:"expiration"`
+	ViolationLimit    int                 `json:"violationTimeLimitSeconds"`
+	Tags              map[string][]string `json:"tags"`
+	Permalink         string              `json:"permalink"`
+}
+type ExportNrql struct {
+	Query string `json:"query"`
+}
+type ExportSignal struct {
+	AggregationWindow int      `json:"aggregationWindow"`
+	AggregationMethod string   `json:"aggregationMethod"`
+	AggregationDelay  *int     `json:"aggregationDelay"`
+	AggregationTimer  *int     `json:"aggregationTimer"`
+	SlideBy           *int     `json:"slideBy"`
+	EvaluationDelay   *int     `json:"evaluationDelay"`
+	FillOption        string   `json:"fillOption"`
+	FillValue         *float64 `json:"fillValue"`
+}
+
+// One NDJSON line: a condition with its account and policy
+type ExportLine struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	AccountId     int              `json:"accountId"`
+	Policy        ExportPolicyInfo `json:"policy"`
+	Condition     ExportCondition  `json:"condition"`
+}
+
+func exportPolicy(policy Policy) ExportPolicyInfo {
+	tags := policy.Tags
+	if tags == nil {
+		tags = map[string][]string{}
+	}
+	return ExportPolicyInfo{
+		Id:                 policy.Id,
+		Guid:               policy.Guid,
+		Name:               policy.Name,
+		IncidentPreference: policy.IncidentPreference,
+		Tags:               tags,
+	}
+}
+
+func exportCondition(condition Condition) ExportCondition {
+	terms := condition.Terms
+	if terms == nil {
+		terms = []Term{}
+	}
+	tags := condition.Tags
+	if tags == nil {
+		tags = map[string][]string{}
+	}
+	return ExportCondition{
+		Id:                condition.Id,
+		Guid:              condition.Guid,
+		Name:              condition.Name,
+		PolicyId:          condition.PolicyId,
+		Type:              condition.Type,
+		NrqlType:          condition.NrqlType,
+		Enabled:           condition.Enabled,
+		UpdatedAt:         condition.UpdatedAt,
+		Description:       condition.Description,
+		RunbookURL:        condition.RunbookURL,
+		Nrql:              ExportNrql{Query: condition.Query},
+		BaselineDirection: condition.BaselineDirection,
+		Terms:             terms,
+		Signal: ExportSignal{
+			AggregationWindow: condition.AggWindow,
+			AggregationMethod: condition.AggMethod,
+			AggregationDelay:  condition.AggDelay,
+			AggregationTimer:  condition.AggTimer,
+			SlideBy:           condition.SlideBy,
+			EvaluationDelay:   condition.EvaluationDelay,
+			FillOption:        condition.Fill,
+			FillValue:         condition.FillValue,
+		},
+		Expiration:     condition.Expiration,
+		ViolationLimit: condition.ViolationLimit,
+		Tags:           tags,
+		Permalink:      PermalinkURL + condition.Guid,
+	}
+}
+
+// Write the inventory as a JSON document, or as NDJSON with one condition per line
+func (data *LocalData) writeExport() (err error) {
+	if data.ExportFormat != "json" && data.ExportFormat != "ndjson" {
+		return fmt.Errorf("unknown export format %q, use json or ndjson", data.ExportFormat)
+	}
+	data.getPolicyTags()
+
+	var w io.Writer = os.Stdout
+	if data.ExportFile != "-" {
+		var f *os.File
+		if f, err = os.Create(data.ExportFile); err != nil {
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var count int
+	if data.ExportFormat == "ndjson" {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, policyId := range data.PolicyIds {
+			policy := data.PolicyMap[policyId]
+			for _, conditionId := range policy.ConditionIds {
+				line := ExportLine{
+					SchemaVersion: ExportSchemaVersion,
+					AccountId:     data.AccountId,
+					Policy:        exportPolicy(policy),
+					Condition:     exportCondition(data.ConditionMap[conditionId]),
+				}
+				if err = enc.Encode(line); err != nil {
+					return
+				}
+				count++
+			}
+		}
+	} else {
+		export := Export{
+			SchemaVersion: ExportSchemaVersion,
+			GeneratedAt:   time.Now().UTC(),
+			Account:       ExportAccount{Id: data.AccountId},
+			Policies:      []ExportPolicy{},
+		}
+		for _, policyId := range data.PolicyIds {
+			policy := data.PolicyMap[policyId]
+			p := ExportPolicy{ExportPolicyInfo: exportPolicy(policy), Conditions: []ExportCondition{}}
+			for _, conditionId := range policy.ConditionIds {
+				p.Conditions = append(p.Conditions, exportCondition(data.ConditionMap[conditionId]))
+				count++
+			}
+			export.Policies = append(export.Policies, p)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(export); err != nil {
+			return
+		}
+	}
+	log.Printf("Exported %d policies and %d conditions as %s to %s", len(data.PolicyIds), count, data.ExportFormat, data.ExportFile)
+	return
+}
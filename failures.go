@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const FailuresFile = "failures.json"
+
+// A condition whose Terraform could not be scraped
+type ScrapeFailure struct {
+	PolicyId    string `json:"policyId"`
+	ConditionId string `json:"conditionId"`
+	Guid        string `json:"guid"`
+	Name        string `json:"name"`
+	Error       string `json:"error"`
+}
+
+func (data *LocalData) addFailure(policy Policy, condition Condition, err error) {
+	data.failureLock.Lock()
+	defer data.failureLock.Unlock()
+	data.Failures = append(data.Failures, ScrapeFailure{
+		PolicyId:    policy.Id,
+		ConditionId: condition.Id,
+		Guid:        condition.Guid,
+		Name:        condition.Name,
+		Error:       err.Error(),
+	})
+}
+
+// Write the failure report, replacing the one from any previous run
+func (data *LocalData) writeFailures() {
+	failures := data.Failures
+	if failures == nil {
+		failures = []ScrapeFailure{}
+	}
+	b, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		log.Printf("Error creating %s: %v", FailuresFile, err)
+		return
+	}
+	log.Printf("Writing %d scrape failures to %s", len(failures), FailuresFile)
+	err = os.WriteFile(FailuresFile, b, 0644)
+	if err != nil {
+		log.Printf("Error writing %s: %v", FailuresFile, err)
+	}
+}
+
+// Restrict scraping to the conditions that failed in the previous run
+func (data *LocalData) loadFailures() (err error) {
+	b, err := os.ReadFile(FailuresFile)
+	if err != nil {
+		return
+	}
+	var failures []ScrapeFailure
+	if err = json.Unmarshal(b, &failures); err != nil {
+		return fmt.Errorf("invalid %s: %v", FailuresFile, err)
+	}
+	data.RerunIds = make(map[int]bool)
+	for _, failure := range failures {
+		var id int
+		if id, err = strconv.Atoi(failure.ConditionId); err != nil {
+			return fmt.Errorf("invalid condition id in %s: %q", FailuresFile, failure.ConditionId)
+		}
+		data.RerunIds[id] = true
+	}
+
+	// Keep only policies with failed conditions that still exist
+	var policyIds []int
+	var count int
+	for _, policyId := range data.PolicyIds {
+		var found bool
+		for _, conditionId := range data.PolicyMap[policyId].ConditionIds {
+			if data.RerunIds[conditionId] {
+				found = true
+				count++
+			}
+		}
+		if found {
+			policyIds = append(policyIds, policyId)
+		}
+	}
+	data.PolicyIds = policyIds
+	log.Printf("Re-running %d of %d failed conditions in %d policies", count, len(failures), len(policyIds))
+	return nil
+}
+
+// Splice re-run conditions into an existing policy file, keeping the
+// conditions in ConditionIds order. A missing file is only written when every
+// condition of the policy was scraped, since a partial one would drop the rest.
+// Returns whether the file now has the conditions.
+func (data *LocalData) spliceTF(policy Policy, texts map[int]string) (ok bool) {
+	if len(texts) == 0 {
+		return true
+	}
+	filename := fmt.Sprintf("policy_%s.tf", policy.Id)
+	existing, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		if len(texts) == len(policy.ConditionIds) {
+			policy.makeTF(texts)
+			policy.writeTF(".")
+			return true
+		}
+		log.Printf("Not writing %s with only %d of its %d conditions, it is missing so run without -rerun-failures to write it in full",
+			filename, len(texts), len(policy.ConditionIds))
+		err = fmt.Errorf("%s is missing, run without -rerun-failures", filename)
+		for conditionId := range texts {
+			data.addFailure(policy, data.ConditionMap[conditionId], err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading alert policy terraform: %v", err)
+		return
+	}
+	src := string(existing)
+	merged, err := spliceConditions(src, policy.ConditionIds, texts)
+	if err != nil {
+		log.Printf("Error splicing conditions into %s, leaving it unchanged: %v", filename, err)
+		return
+	}
+	if merged == src {
+		log.Printf("No changes to alert policy terraform %s", filename)
+		return true
+	}
+	log.Printf("Splicing %d conditions into alert policy terraform %s", len(texts), filename)
+	err = os.WriteFile(filename, []byte(merged), 0644)
+	if err != nil {
+		log.Printf("Error writing alert policy terraform: %v", err)
+		return
+	}
+	return true
+}
+
+// Replace or insert the Terraform of some conditions in the source of a
+// policy file. Existing blocks are found by their condition tag, since several
+// conditions can share a name and a resource label. Untagged blocks, from
+// files written before tags were added, are never replaced.
+func spliceConditions(src string, conditionIds []int, texts map[int]string) (string, error) {
+	body, err := parseTF(src)
+	if err != nil {
+		return "", err
+	}
+	blocks := make(map[string]*TFItem)
+	for _, item := range body.Items {
+		if item.Key != "resource" || len(item.Labels) != 2 || item.Labels[0] != TFConditionType {
+			continue
+		}
+		if tag := conditionTagOf(src, item); len(tag) > 0 {
+			if _, found := blocks[tag]; found {
+				return "", fmt.Errorf("condition %s is in the file twice", tag)
+			}
+			blocks[tag] = item
+		}
+	}
+
+	var edits []tfEdit
+	var appended bool
+	for i, conditionId := range conditionIds {
+		text, ok := texts[conditionId]
+		if !ok {
+			continue
+		}
+		text = tagCondition(text, strconv.Itoa(conditionId))
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+
+		// Replace a block already there, otherwise insert before the next one
+		if item, found := blocks[strconv.Itoa(conditionId)]; found {
+			merged, err := mergeTF(src[item.Start:item.End], text, nil)
+			if err != nil {
+				return "", fmt.Errorf("merging condition %d: %v", conditionId, err)
+			}
+			edits = append(edits, tfEdit{item.Start, item.End, merged})
+			continue
+		}
+		insertAt := len(src)
+		for _, nextId := range conditionIds[i+1:] {
+			if item, found := blocks[strconv.Itoa(nextId)]; found {
+				insertAt = item.Start
+				break
+			}
+		}
+		switch {
+		case insertAt < len(src):
+			text += "\n"
+		case appended:
+			text = "\n" + text
+		case strings.HasSuffix(src, "\n\n"):
+		case strings.HasSuffix(src, "\n"):
+			text = "\n" + text
+		default:
+			text = "\n\n" + text
+		}
+		edits = append(edits, tfEdit{insertAt, insertAt, text})
+		appended = appended || insertAt == len(src)
+	}
+	return applyEdits(src, edits)
+}
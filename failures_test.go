@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpliceConditionsSameName(t *testing.T) {
+	condition := func(name, query string) string {
+		return "resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = \"" + name + "\"\n  nrql {\n    query = \"" + query + "\"\n  }\n}\n"
+	}
+	policy := "resource \"newrelic_alert_policy\" \"policy_1\" {\n  name = \"one\"\n}\n\n"
+
+	// Conditions 11 and 13 have the same name, 12 and 13 failed last time
+	src := policy + tagCondition(condition("CPU", "first"), "11") + "\n" + tagCondition(condition("Other", "other"), "14")
+	conditionIds := []int{11, 12, 13, 14}
+	texts := map[int]string{12: condition("Memory", "memory"), 13: condition("CPU", "second")}
+	got, err := spliceConditions(src, conditionIds, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := policy + tagCondition(condition("CPU", "first"), "11") + "\n" +
+		tagCondition(condition("Memory", "memory"), "12") + "\n" +
+		tagCondition(condition("CPU", "second"), "13") + "\n" +
+		tagCondition(condition("Other", "other"), "14")
+	if got != want {
+		t.Errorf("spliced:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A second re-run replaces the right one of the two same-named conditions
+	texts = map[int]string{13: condition("CPU", "changed")}
+	again, err := spliceConditions(got, conditionIds, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, `"second"`, `"changed"`, 1)
+	if again != want {
+		t.Errorf("spliced again:\n%s\nwant:\n%s", again, want)
+	}
+}
+
+func TestSpliceConditionsDuplicateTag(t *testing.T) {
+	block := tagCondition("resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = \"CPU\"\n}\n", "11")
+	if _, err := spliceConditions(block+"\n"+block, []int{11}, map[int]string{11: block}); err == nil {
+		t.Error("a condition tagged twice did not fail")
+	}
+}
+
+func TestMergeTFConditionTags(t *testing.T) {
+	condition := "resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = \"CPU\"\n  enabled = %s\n}\n"
+	old := tagCondition(strings.Replace(condition, "%s", "true", 1), "11") + "\n" + tagCondition(strings.Replace(condition, "%s", "true", 1), "12")
+
+	// Only condition 12 is still generated, so the block tagged 11 goes
+	generated := tagCondition(strings.Replace(condition, "%s", "false", 1), "12")
+	got, err := mergeTF(old, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != generated {
+		t.Errorf("merged:\n%s\nwant:\n%s", got, generated)
+	}
+}
+
+func TestMergeTFKeepsFailedConditions(t *testing.T) {
+	condition := "resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = \"CPU\"\n  enabled = %s\n}\n"
+	old := tagCondition(strings.Replace(condition, "%s", "true", 1), "11") + "\n" + tagCondition(strings.Replace(condition, "%s", "true", 1), "12")
+
+	// Condition 11 failed to scrape, so its block stays as it was
+	policy := Policy{ConditionIds: []int{11, 12}, Managed: true}
+	policy.makeTF(map[int]string{12: strings.Replace(condition, "%s", "false", 1)})
+	got, err := mergeTF(old, policy.TF, policy.Failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := tagCondition(strings.Replace(condition, "%s", "true", 1), "11") + "\n" + tagCondition(strings.Replace(condition, "%s", "false", 1), "12")
+	if got != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", got, want)
+	}
+}
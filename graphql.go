@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,41 +16,72 @@ import (
 const (
 	GraphQlEndpoint = "https://api.newrelic.com/graphql"
 	GrQl_Parallel   = 10
-	PolicyQuery     = `query($cursor: String) {actor {account(id: %d) {alerts {policiesSearch(cursor: $cursor) {policies {id incidentPreference name accountId} nextCursor}}}}}`
-	ConditionQuery  = `query EntitySearchQuery($cursor: String) {actor {entitySearch(query: "domain = 'AIOPS' AND type = 'CONDITION' AND accountId = %d", options: {tagFilter: ["id","policyId","enabled","type"]}) {results(cursor: $cursor) {entities {guid accountId type name tags {key values}} nextCursor}}}}`
-	DetailQuery     = `query getConditionDetail($accountId: Int!, $conditionId: ID!) {actor {account(id: $accountId) {alerts {nrqlCondition(id: $conditionId) {nrql {query} name id}}}}}`
+	PolicyTagQuery  = `query EntitySearchQuery($cursor: String) {actor {entitySearch(query: "domain = 'AIOPS' AND type = 'POLICY' AND accountId = %d") {results(cursor: $cursor) {entities {guid accountId type name tags {key values}} nextCursor}}}}`
+	PolicyQuery     = `query($cursor: String) {actor {account(id: %d) {alerts {policiesSearch(cursor: $cursor) {policies {id incidentPreference name accountId} nextCursor totalCount}}}}}`
+	ConditionQuery  = `query EntitySearchQuery($cursor: String) {actor {entitySearch(query: "domain = 'AIOPS' AND type = 'CONDITION' AND accountId = %d", options: {tagFilter: ["id","policyId","enabled","type"]}) {count results(cursor: $cursor) {entities {guid accountId type name tags {key values}} nextCursor}}}}`
+	DetailQuery     = `query getConditionDetail($accountId: Int!, $conditionId: ID!) {actor {account(id: $accountId) {alerts {nrqlCondition(id: $conditionId) {nrql {query} name id type enabled updatedAt description runbookUrl terms {operator priority threshold thresholdDuration thresholdOccurrences} signal {aggregationWindow aggregationMethod aggregationDelay aggregationTimer slideBy evaluationDelay fillOption fillValue} violationTimeLimitSeconds expiration {expirationDuration openViolationOnExpiration closeViolationsOnExpiration} ... on AlertsNrqlBaselineCondition {baselineDirection}}}}}}`
 	DisableBQuery   = `mutation disableNrqlBaselineCondition($conditionId: ID!, $accountId: Int!) {alertsNrqlConditionBaselineUpdate(accountId: $accountId, condition: {enabled: false}, id: $conditionId) {enabled name}}`
 	DisableSQuery   = `mutation disableNrqlStaticCondition($conditionId: ID!, $accountId: Int!) {alertsNrqlConditionStaticUpdate(accountId: $accountId, condition: {enabled: false}, id: $conditionId) {enabled name}}`
+	UpdateBQuery    = `mutation updateNrqlBaselineCondition($conditionId: ID!, $accountId: Int!, $condition: AlertsNrqlConditionUpdateBaselineInput!) {alertsNrqlConditionBaselineUpdate(accountId: $accountId, condition: $condition, id: $conditionId) {id name enabled nrql {query}}}`
+	UpdateSQuery    = `mutation updateNrqlStaticCondition($conditionId: ID!, $accountId: Int!, $condition: AlertsNrqlConditionUpdateStaticInput!) {alertsNrqlConditionStaticUpdate(accountId: $accountId, condition: $condition, id: $conditionId) {id name enabled nrql {query}}}`
 )
 
 // Alert entities
 type Policy struct {
-	AccountId          int    `json:"accountId"`
-	Id                 string `json:"id"`
-	Name               string `json:"name"`
-	IncidentPreference string `json:"IncidentPreference"`
-	ConditionIds       []int
-	TF                 string
+	AccountId          int                 `json:"accountId"`
+	Id                 string              `json:"id"`
+	Name               string              `json:"name"`
+	IncidentPreference string              `json:"incidentPreference"`
+	ConditionIds       []int               `json:"conditionIds"`
+	TF                 string              `json:"-"`
+	Failed             map[string]bool     `json:"-"`
+	Managed            bool                `json:"-"`
+	Guid               string              `json:"guid,omitempty"`
+	Tags               map[string][]string `json:"tags,omitempty"`
 }
 type Condition struct {
-	AccountId int    `json:"accountId"`
-	PolicyId  string `json:"policyId"`
-	Id        string `json:"id"`
-	Name      string `json:"name"`
-	Guid      string `json:"guid"`
-	Type      string
-	Query     string
-	Enabled   bool
-	Critical  Threshold
-	Warning   Threshold
-	Fill      string
-	AggWindow int
+	AccountId int       `json:"accountId"`
+	PolicyId  string    `json:"policyId"`
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Guid      string    `json:"guid"`
+	Type      string    `json:"type"`
+	Query     string    `json:"query"`
+	Enabled   bool      `json:"enabled"`
+	Critical  Threshold `json:"critical"`
+	Warning   Threshold `json:"warning"`
+	Fill      string    `json:"fillOption"`
+	FillValue *float64  `json:"fillValue"`
+	AggWindow int       `json:"aggregationWindow"`
+	Managed   bool      `json:"-"`
+
+	Description       string              `json:"description"`
+	RunbookURL        string              `json:"runbookUrl"`
+	Expiration        Expiration          `json:"expiration"`
+	Terms             []Term              `json:"terms"`
+	Tags              map[string][]string `json:"tags"`
+	NrqlType          string              `json:"nrqlType"`
+	BaselineDirection string              `json:"baselineDirection,omitempty"`
+	UpdatedAt         int64               `json:"updatedAt,omitempty"`
+	AggMethod         string              `json:"aggregationMethod,omitempty"`
+	AggDelay          *int                `json:"aggregationDelay,omitempty"`
+	AggTimer          *int                `json:"aggregationTimer,omitempty"`
+	SlideBy           *int                `json:"slideBy,omitempty"`
+	EvaluationDelay   *int                `json:"evaluationDelay,omitempty"`
+	ViolationLimit    int                 `json:"violationTimeLimitSeconds,omitempty"`
+}
+
+// Signal loss settings
+type Expiration struct {
+	ExpirationDuration          *int `json:"expirationDuration"`
+	OpenViolationOnExpiration   bool `json:"openViolationOnExpiration"`
+	CloseViolationsOnExpiration bool `json:"closeViolationsOnExpiration"`
 }
 type Threshold struct {
-	Operator             string
-	Threshold            int
-	ThresholdDuration    int
-	thresholdOccurrences string
+	Operator             string  `json:"operator"`
+	Threshold            float64 `json:"threshold"`
+	ThresholdDuration    int     `json:"thresholdDuration"`
+	ThresholdOccurrences string  `json:"thresholdOccurrences"`
 }
 type Entity struct {
 	AccountId int    `json:"accountId"`
@@ -63,16 +95,18 @@ type Entity struct {
 }
 type Output struct {
 	ConditionId int
-	Query       string
+	Detail      NrqlCondition
+	NotFound    bool
 }
 
 // GraphQl request and result formats
 type GraphQlPayload struct {
 	Query     string `json:"query"`
 	Variables struct {
-		AccountId   int    `json:"accountId,omitempty"`
-		ConditionId string `json:"conditionId,omitempty"`
-		Cursor      string `json:"cursor,omitempty"`
+		AccountId   int              `json:"accountId,omitempty"`
+		ConditionId string           `json:"conditionId,omitempty"`
+		Cursor      string           `json:"cursor,omitempty"`
+		Condition   *ConditionUpdate `json:"condition,omitempty"`
 	} `json:"variables"`
 }
 type GraphQlResult struct {
@@ -80,6 +114,7 @@ type GraphQlResult struct {
 	Data   struct {
 		Actor struct {
 			EntitySearch struct {
+				Count   int `json:"count"`
 				Results struct {
 					Entities   []Entity    `json:"entities"`
 					NextCursor interface{} `json:"nextCursor"`
@@ -91,24 +126,47 @@ type GraphQlResult struct {
 					PoliciesSearch struct {
 						Policies   []Policy    `json:"policies"`
 						NextCursor interface{} `json:"nextCursor"`
+						TotalCount int         `json:"totalCount"`
 					} `json:"policiesSearch"`
 				} `json:"alerts"`
 			} `json:"account"`
 		} `json:"actor"`
-		DisableB struct {
-			Enabled bool `json:"enabled"`
-		} `json:"alertsNrqlConditionBaselineUpdate"`
-		DisableS struct {
-			Enabled bool `json:"enabled"`
-		} `json:"alertsNrqlConditionStaticUpdate"`
+		UpdateB NrqlCondition `json:"alertsNrqlConditionBaselineUpdate"`
+		UpdateS NrqlCondition `json:"alertsNrqlConditionStaticUpdate"`
 	} `json:"data"`
 }
 type NrqlCondition struct {
-	Id   string `json:"id"`
-	Name string `json:"name"`
-	Nrql struct {
+	Id                string `json:"id"`
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	Enabled           bool   `json:"enabled"`
+	UpdatedAt         int64  `json:"updatedAt"`
+	Description       string `json:"description"`
+	BaselineDirection string `json:"baselineDirection"`
+	RunbookURL        string `json:"runbookUrl"`
+	Nrql              struct {
 		Query string `json:"query"`
 	} `json:"nrql"`
+	Terms  []Term `json:"terms"`
+	Signal struct {
+		AggregationWindow int      `json:"aggregationWindow"`
+		AggregationMethod string   `json:"aggregationMethod"`
+		AggregationDelay  *int     `json:"aggregationDelay"`
+		AggregationTimer  *int     `json:"aggregationTimer"`
+		SlideBy           *int     `json:"slideBy"`
+		EvaluationDelay   *int     `json:"evaluationDelay"`
+		FillOption        string   `json:"fillOption"`
+		FillValue         *float64 `json:"fillValue"`
+	} `json:"signal"`
+	Expiration                Expiration `json:"expiration"`
+	ViolationTimeLimitSeconds int        `json:"violationTimeLimitSeconds"`
+}
+type Term struct {
+	Operator             string  `json:"operator"`
+	Priority             string  `json:"priority"`
+	Threshold            float64 `json:"threshold"`
+	ThresholdDuration    int     `json:"thresholdDuration"`
+	ThresholdOccurrences string  `json:"thresholdOccurrences"`
 }
 type Error struct {
 	Message string `json:"message"`
@@ -160,6 +218,7 @@ func (data *LocalData) getPolicies() {
 	var err error
 
 	// Get Policies with IncidentPreference
+	data.Progress.begin("policies", 0)
 	gQuery.Query = fmt.Sprintf(PolicyQuery, data.AccountId)
 	for {
 		// make query payload
@@ -180,6 +239,7 @@ func (data *LocalData) getPolicies() {
 			log.Printf("Errors with GraphQl query: %v", graphQlResult.Errors)
 		}
 		policiesSearch := graphQlResult.Data.Actor.Account.Alerts.PoliciesSearch
+		data.Progress.setTotal(policiesSearch.TotalCount)
 
 		// store policies
 		for _, policy := range policiesSearch.Policies {
@@ -187,9 +247,11 @@ func (data *LocalData) getPolicies() {
 			id, err = strconv.Atoi(policy.Id)
 			if err != nil {
 				log.Printf("Error parsing policy Id: %v (policy %+v)", err, policy)
+				data.Progress.fail()
 				continue
 			}
 			data.PolicyMap[id] = policy
+			data.Progress.add(1)
 		}
 		if policiesSearch.NextCursor == nil {
 			break
@@ -204,6 +266,7 @@ func (data *LocalData) getPolicies() {
 		data.PolicyIds = append(data.PolicyIds, policyId)
 	}
 	sort.Ints(data.PolicyIds)
+	data.Progress.finish()
 	log.Printf("Found %d policies", len(data.PolicyMap))
 }
 
@@ -211,39 +274,45 @@ func parseCondition(entity Entity) (condition Condition, err error) {
 	condition.Guid = entity.Guid
 	condition.Name = entity.Name
 	condition.AccountId = entity.AccountId
+	condition.Tags = make(map[string][]string)
+	for _, tag := range entity.Tags {
+		condition.Tags[tag.Key] = tag.Values
+	}
 	if entity.Type != "CONDITION" {
-		err = fmt.Errorf("invalid condition type: %+v", entity)
+		err = fmt.Errorf("invalid condition entity type %s", entity.Type)
 		return
 	}
-	if len(entity.Tags) != 4 {
-		err = fmt.Errorf("invalid condition tags: %+v", entity)
-		return
+	for _, key := range []string{"id", "policyId", "type", "enabled"} {
+		if _, ok := condition.Tags[key]; !ok {
+			err = fmt.Errorf("condition entity has no %s tag", key)
+			return
+		}
 	}
 	for _, tag := range entity.Tags {
 		if tag.Key == "policyId" {
 			if len(tag.Values) != 1 {
-				err = fmt.Errorf("invalid condition entity PolicyId: %+v", entity)
+				err = fmt.Errorf("condition entity has %d policyId tag values", len(tag.Values))
 				return
 			}
 			condition.PolicyId = tag.Values[0]
 		}
 		if tag.Key == "id" {
 			if len(tag.Values) != 1 {
-				err = fmt.Errorf("invalid condition entity Id: %+v", entity)
+				err = fmt.Errorf("condition entity has %d id tag values", len(tag.Values))
 				return
 			}
 			condition.Id = tag.Values[0]
 		}
 		if tag.Key == "type" {
 			if len(tag.Values) != 1 {
-				err = fmt.Errorf("invalid condition entity Type: %+v", entity)
+				err = fmt.Errorf("condition entity has %d type tag values", len(tag.Values))
 				return
 			}
 			condition.Type = tag.Values[0]
 		}
 		if tag.Key == "enabled" {
 			if len(tag.Values) != 1 {
-				err = fmt.Errorf("invalid condition entity Enabled: %+v", entity)
+				err = fmt.Errorf("condition entity has %d enabled tag values", len(tag.Values))
 				return
 			}
 			if tag.Values[0] == "true" {
@@ -254,9 +323,54 @@ func parseCondition(entity Entity) (condition Condition, err error) {
 	return
 }
 
+// Copy NRQL condition details into the condition
+func (condition *Condition) setDetail(detail NrqlCondition) {
+	condition.Query = detail.Nrql.Query
+	condition.Enabled = detail.Enabled
+	condition.Fill = detail.Signal.FillOption
+	condition.FillValue = detail.Signal.FillValue
+	condition.AggWindow = detail.Signal.AggregationWindow
+	condition.AggMethod = detail.Signal.AggregationMethod
+	condition.AggDelay = detail.Signal.AggregationDelay
+	condition.AggTimer = detail.Signal.AggregationTimer
+	condition.SlideBy = detail.Signal.SlideBy
+	condition.EvaluationDelay = detail.Signal.EvaluationDelay
+	condition.ViolationLimit = detail.ViolationTimeLimitSeconds
+	condition.Description = detail.Description
+	condition.RunbookURL = detail.RunbookURL
+	condition.Expiration = detail.Expiration
+	condition.Terms = detail.Terms
+	condition.NrqlType = detail.Type
+	condition.BaselineDirection = detail.BaselineDirection
+	condition.UpdatedAt = detail.UpdatedAt
+	for _, term := range detail.Terms {
+		threshold := Threshold{
+			Operator:             term.Operator,
+			Threshold:            term.Threshold,
+			ThresholdDuration:    term.ThresholdDuration,
+			ThresholdOccurrences: term.ThresholdOccurrences,
+		}
+		if term.Priority == "WARNING" {
+			condition.Warning = threshold
+		} else {
+			condition.Critical = threshold
+		}
+	}
+}
+
 func (data *LocalData) getConditionDetails() {
-	inputChan := make(chan int, len(data.ConditionMap)+GrQl_Parallel)
-	outputChan := make(chan Output, len(data.ConditionMap)+GrQl_Parallel)
+	// Orphaned conditions get details too, so -include-orphans has their NRQL
+	orphans := make(map[int]int)
+	for i, condition := range data.Consistency.Orphans {
+		if id, err := strconv.Atoi(condition.Id); err == nil {
+			orphans[id] = i
+		}
+	}
+	total := len(data.ConditionMap) + len(orphans)
+	inputChan := make(chan int, total+GrQl_Parallel)
+	outputChan := make(chan Output, total+GrQl_Parallel)
+
+	data.Progress.begin("details", total)
 
 	// Load conditions into channel
 	go func() {
@@ -268,6 +382,9 @@ func (data *LocalData) getConditionDetails() {
 				inputChan <- id
 			}
 		}
+		for id := range orphans {
+			inputChan <- id
+		}
 		for n := 0; n < GrQl_Parallel; n++ {
 			inputChan <- 0
 		}
@@ -293,6 +410,7 @@ func (data *LocalData) getConditionDetails() {
 				j, err = json.Marshal(gQuery)
 				if err != nil {
 					log.Printf("Error creating GraphQl condition detail query: %v", err)
+					data.Progress.fail()
 					continue
 				}
 				b := retryQuery(client, "POST", GraphQlEndpoint, string(j), data.GraphQlHeaders)
@@ -301,18 +419,21 @@ func (data *LocalData) getConditionDetails() {
 				err = json.Unmarshal(b, &graphQlResult)
 				if err != nil {
 					log.Printf("Error parsing GraphQl condition detail result: %v", err)
+					data.Progress.fail()
 					continue
 				}
 				if len(graphQlResult.Errors) > 0 {
 					if graphQlResult.Errors[0].Message == "Not Found" {
+						outputChan <- Output{ConditionId: conditionId, NotFound: true}
 						continue
 					}
 					log.Printf("Errors with GraphQl query: %v", graphQlResult.Errors)
+					data.Progress.fail()
 					continue
 				}
 				outputChan <- Output{
 					ConditionId: conditionId,
-					Query:       graphQlResult.Data.Actor.Account.Alerts.NrqlCondition.Nrql.Query,
+					Detail:      graphQlResult.Data.Actor.Account.Alerts.NrqlCondition,
 				}
 			}
 		}()
@@ -333,15 +454,36 @@ func (data *LocalData) getConditionDetails() {
 				log.Printf("GraphQL - ending condition detail requestor #%d", i+1)
 				break
 			}
+			data.Progress.add(1)
 			condition, ok := data.ConditionMap[output.ConditionId]
-			if !ok {
+			orphan, isOrphan := orphans[output.ConditionId]
+			if isOrphan {
+				condition = data.Consistency.Orphans[orphan]
+			} else if !ok {
 				log.Printf("GraphQL condition detail, no condition for id %d", output.ConditionId)
 				continue
 			}
-			condition.Query = output.Query
+			if output.NotFound {
+				// Not Found is expected for conditions that are not NRQL
+				if strings.HasPrefix(condition.Type, "NRQL") {
+					data.Consistency.NotFound = append(data.Consistency.NotFound, ConsistencyDetail{
+						Id:       condition.Id,
+						Name:     condition.Name,
+						PolicyId: condition.PolicyId,
+						Type:     condition.Type,
+					})
+				}
+				continue
+			}
+			condition.setDetail(output.Detail)
+
+			if isOrphan {
+				data.Consistency.Orphans[orphan] = condition
+				continue
+			}
 
 			// disable option
-			if data.Disable && condition.Type[0:4] == "NRQL" && condition.Enabled {
+			if data.Disable && strings.HasPrefix(condition.Type, "NRQL") && condition.Enabled {
 				if condition.Type == "NRQL Query" {
 					gQuery.Query = DisableSQuery
 				} else {
@@ -364,9 +506,9 @@ func (data *LocalData) getConditionDetails() {
 							log.Printf("Errors with GraphQl condition.id %s disable mutation: %v", condition.Id, graphQlResult.Errors)
 						} else {
 							if condition.Type == "NRQL Query" {
-								condition.Enabled = graphQlResult.Data.DisableS.Enabled
+								condition.Enabled = graphQlResult.Data.UpdateS.Enabled
 							} else {
-								condition.Enabled = graphQlResult.Data.DisableB.Enabled
+								condition.Enabled = graphQlResult.Data.UpdateB.Enabled
 							}
 						}
 						if !condition.Enabled {
@@ -380,6 +522,7 @@ func (data *LocalData) getConditionDetails() {
 			queries++
 		}
 	}
+	data.Progress.finish()
 	log.Printf("GraphQL - finished condition detail requesters, %d nrql conditions found", queries)
 	if data.Disable {
 		log.Printf("GraphQL - disabled %d nrql conditions", disableCount)
@@ -393,6 +536,7 @@ func (data *LocalData) getConditions() {
 	var conditionCount int
 
 	// Get conditions, story in Policy map by guid
+	data.Progress.begin("conditions", 0)
 	gQuery.Query = fmt.Sprintf(ConditionQuery, data.AccountId)
 	for {
 		// make query payload
@@ -413,6 +557,7 @@ func (data *LocalData) getConditions() {
 			log.Printf("Errors with GraphQl query: %v", graphQlResult.Errors)
 		}
 		conditionsSearch := graphQlResult.Data.Actor.EntitySearch.Results
+		data.Progress.setTotal(graphQlResult.Data.Actor.EntitySearch.Count)
 
 		// store conditions
 		for _, entity := range conditionsSearch.Entities {
@@ -422,29 +567,28 @@ func (data *LocalData) getConditions() {
 			var id, policyId int
 
 			condition, err = parseCondition(entity)
-			if err != nil {
-				log.Printf("Error parsing condition: %v", err)
-				continue
+			if err == nil {
+				policyId, err = strconv.Atoi(condition.PolicyId)
+			}
+			if err == nil {
+				id, err = strconv.Atoi(condition.Id)
 			}
-			policyId, err = strconv.Atoi(condition.PolicyId)
 			if err != nil {
-				log.Printf("Error parsing condition policyId: %v (condition %+v)", err, condition)
+				data.Consistency.malformed(entity, err)
+				data.Progress.fail()
 				continue
 			}
 			policy, ok = data.PolicyMap[policyId]
 			if !ok {
-				log.Printf("Error locating policy for conditon: %+v", condition)
-				continue
-			}
-			id, err = strconv.Atoi(condition.Id)
-			if err != nil {
-				log.Printf("Error parsing condition Id: %v (condition %+v)", err, condition)
+				data.Consistency.Orphans = append(data.Consistency.Orphans, condition)
+				data.Progress.fail()
 				continue
 			}
 			data.ConditionMap[id] = condition
 			policy.ConditionIds = append(policy.ConditionIds, id)
 			data.PolicyMap[policyId] = policy
 			conditionCount++
+			data.Progress.add(1)
 		}
 		if conditionsSearch.NextCursor == nil {
 			break
@@ -452,9 +596,92 @@ func (data *LocalData) getConditions() {
 		// get next page of results
 		gQuery.Variables.Cursor = fmt.Sprintf("%s", conditionsSearch.NextCursor)
 	}
+	data.Progress.finish()
 	log.Printf("Found %d conditions", conditionCount)
 }
 
+// Tags every policy entity has, which are not policy level tags
+var systemPolicyTags = map[string]bool{"account": true, "accountId": true, "id": true, "policyId": true, "trustedAccountId": true}
+
+// Policy id of a policy entity, from its tags or else its GUID, which
+// encodes account|AIOPS|POLICY|id
+func policyEntityId(entity Entity) (id int, err error) {
+	for _, tag := range entity.Tags {
+		if (tag.Key == "id" || tag.Key == "policyId") && len(tag.Values) == 1 {
+			return strconv.Atoi(tag.Values[0])
+		}
+	}
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(entity.Guid, "="))
+	if err != nil {
+		return
+	}
+	parts := strings.Split(string(b), "|")
+	return strconv.Atoi(parts[len(parts)-1])
+}
+
+// Get policy entities for their GUIDs and tags, unless already known
+func (data *LocalData) getPolicyTags() {
+	if data.tagsFetched {
+		return
+	}
+	data.tagsFetched = true
+	var gQuery GraphQlPayload
+	var j []byte
+	var err error
+	var tagCount int
+
+	gQuery.Query = fmt.Sprintf(PolicyTagQuery, data.AccountId)
+	for {
+		// make query payload
+		j, err = json.Marshal(gQuery)
+		if err != nil {
+			log.Printf("Error creating GraphQl policy entities query: %v", err)
+		}
+		b := retryQuery(data.Client, "POST", GraphQlEndpoint, string(j), data.GraphQlHeaders)
+
+		// parse results
+		var graphQlResult GraphQlResult
+		log.Printf("Parsing GraphQl policy entities response %d bytes", len(b))
+		err = json.Unmarshal(b, &graphQlResult)
+		if err != nil {
+			log.Printf("Error parsing GraphQl policy entities result: %v", err)
+		}
+		if len(graphQlResult.Errors) > 0 {
+			log.Printf("Errors with GraphQl query: %v", graphQlResult.Errors)
+		}
+		policiesSearch := graphQlResult.Data.Actor.EntitySearch.Results
+
+		// store tags
+		for _, entity := range policiesSearch.Entities {
+			var id int
+			id, err = policyEntityId(entity)
+			if err != nil {
+				log.Printf("Error parsing policy entity Id: %v (entity %+v)", err, entity)
+				continue
+			}
+			policy, ok := data.PolicyMap[id]
+			if !ok {
+				continue
+			}
+			policy.Guid = entity.Guid
+			policy.Tags = make(map[string][]string)
+			for _, tag := range entity.Tags {
+				if !systemPolicyTags[tag.Key] {
+					policy.Tags[tag.Key] = tag.Values
+					tagCount++
+				}
+			}
+			data.PolicyMap[id] = policy
+		}
+		if policiesSearch.NextCursor == nil {
+			break
+		}
+		// get next page of results
+		gQuery.Variables.Cursor = fmt.Sprintf("%s", policiesSearch.NextCursor)
+	}
+	log.Printf("Found %d policy tags", tagCount)
+}
+
 func (data *LocalData) makeClient() {
 	data.Client = &http.Client{}
 	data.GraphQlHeaders = []string{"Content-Type:application/json", "API-Key:" + data.UserKey}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Page templates for the HTML report, with the styles and scripts inline so
+// the pages work offline
+//
+//go:embed report.html
+var reportTemplates string
+
+type ReportIndex struct {
+	AccountId  int
+	Generated  string
+	Conditions int
+	Enabled    int
+	Policies   []ReportPolicySummary
+	Search     []ReportSearchEntry
+}
+type ReportPolicySummary struct {
+	Id                 string
+	Name               string
+	IncidentPreference string
+	File               string
+	Conditions         int
+	Enabled            int
+	Percent            int
+}
+type ReportSearchEntry struct {
+	Name    string `json:"name"`
+	Policy  string `json:"policy"`
+	Query   string `json:"query"`
+	Enabled bool   `json:"enabled"`
+	Href    string `json:"href"`
+}
+type ReportPolicy struct {
+	Id                 string
+	Name               string
+	IncidentPreference string
+	Enabled            int
+	Conditions         []ReportCondition
+}
+type ReportCondition struct {
+	Condition
+	Thresholds []ReportThreshold
+	BuilderURL string
+	Permalink  string
+}
+type ReportThreshold struct {
+	Priority    string
+	Operator    string
+	Threshold   string
+	Duration    int
+	Occurrences string
+}
+
+// Thresholds from the condition terms, or the critical and warning
+// thresholds for conditions without them
+func reportThresholds(condition Condition) (thresholds []ReportThreshold) {
+	terms := condition.Terms
+	if len(terms) == 0 {
+		for i, t := range []Threshold{condition.Critical, condition.Warning} {
+			if len(t.Operator) > 0 {
+				terms = append(terms, Term{Priority: []string{"CRITICAL", "WARNING"}[i], Operator: t.Operator, Threshold: t.Threshold,
+					ThresholdDuration: t.ThresholdDuration, ThresholdOccurrences: t.ThresholdOccurrences})
+			}
+		}
+	}
+	for _, term := range terms {
+		thresholds = append(thresholds, ReportThreshold{
+			Priority:    term.Priority,
+			Operator:    term.Operator,
+			Threshold:   strconv.FormatFloat(term.Threshold, 'f', -1, 64),
+			Duration:    term.ThresholdDuration,
+			Occurrences: term.ThresholdOccurrences,
+		})
+	}
+	return
+}
+
+// Write a browsable HTML report: an index of policies with client side
+// search, and a page per policy with its conditions
+func (data *LocalData) writeHTMLReport() (err error) {
+	pages, err := template.New("report").Parse(reportTemplates)
+	if err != nil {
+		return fmt.Errorf("invalid report templates: %v", err)
+	}
+	if err = data.loadSelectors(); err != nil {
+		return
+	}
+	if err = os.MkdirAll(data.HTMLDir, 0755); err != nil {
+		return
+	}
+
+	index := ReportIndex{
+		AccountId: data.AccountId,
+		Generated: time.Now().UTC().Format(time.RFC1123),
+		Policies:  []ReportPolicySummary{},
+		Search:    []ReportSearchEntry{},
+	}
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		file := fmt.Sprintf("policy_%d.html", policyId)
+		page := ReportPolicy{Id: policy.Id, Name: policy.Name, IncidentPreference: policy.IncidentPreference}
+		for _, conditionId := range policy.ConditionIds {
+			condition := data.ConditionMap[conditionId]
+			if condition.Enabled {
+				page.Enabled++
+			}
+			page.Conditions = append(page.Conditions, ReportCondition{
+				Condition:  condition,
+				Thresholds: reportThresholds(condition),
+				BuilderURL: data.Profile.conditionURL(data.NR1URL, condition.Guid, condition.AccountId),
+				Permalink:  PermalinkURL + condition.Guid,
+			})
+			index.Search = append(index.Search, ReportSearchEntry{
+				Name:    condition.Name,
+				Policy:  policy.Name,
+				Query:   condition.Query,
+				Enabled: condition.Enabled,
+				Href:    fmt.Sprintf("%s#condition-%s", file, condition.Id),
+			})
+		}
+		if err = writePage(pages, "policy", filepath.Join(data.HTMLDir, file), page); err != nil {
+			return
+		}
+
+		summary := ReportPolicySummary{
+			Id:                 policy.Id,
+			Name:               policy.Name,
+			IncidentPreference: policy.IncidentPreference,
+			File:               file,
+			Conditions:         len(page.Conditions),
+			Enabled:            page.Enabled,
+		}
+		if summary.Conditions > 0 {
+			summary.Percent = 100 * summary.Enabled / summary.Conditions
+		}
+		index.Conditions += summary.Conditions
+		index.Enabled += summary.Enabled
+		index.Policies = append(index.Policies, summary)
+	}
+	if err = writePage(pages, "index", filepath.Join(data.HTMLDir, "index.html"), index); err != nil {
+		return
+	}
+	log.Printf("Wrote HTML report of %d policies and %d conditions to %s", len(index.Policies), index.Conditions, data.HTMLDir)
+	return
+}
+
+func writePage(pages *template.Template, name, filename string, page interface{}) error {
+	var b bytes.Buffer
+	if err := pages.ExecuteTemplate(&b, name, page); err != nil {
+		return fmt.Errorf("rendering %s: %v", filename, err)
+	}
+	return os.WriteFile(filename, b.Bytes(), 0644)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const InventoryVersion = 1
+
+// The alert policies and conditions of an account, fetched from NerdGraph
+// or loaded from an inventory file
+type Inventory struct {
+	AccountId    int
+	PolicyIds    []int
+	PolicyMap    map[int]Policy
+	ConditionMap map[int]Condition
+	Consistency  Consistency
+	tagsFetched  bool
+}
+
+// Inventory file contents
+type InventoryFile struct {
+	Version     int          `json:"version"`
+	AccountId   int          `json:"accountId"`
+	FetchedAt   time.Time    `json:"fetchedAt"`
+	Policies    []Policy     `json:"policies"`
+	Conditions  []Condition  `json:"conditions"`
+	Consistency *Consistency `json:"consistency,omitempty"`
+}
+
+// Save everything fetched from NerdGraph, including policy tags, so later
+// runs can work offline from the file
+func (data *LocalData) saveInventory(filename string) (err error) {
+	data.checkPolicyIndex()
+	file := InventoryFile{
+		Version:     InventoryVersion,
+		AccountId:   data.AccountId,
+		FetchedAt:   time.Now().UTC(),
+		Policies:    []Policy{},
+		Conditions:  []Condition{},
+		Consistency: &data.Consistency,
+	}
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		file.Policies = append(file.Policies, policy)
+		for _, conditionId := range policy.ConditionIds {
+			file.Conditions = append(file.Conditions, data.ConditionMap[conditionId])
+		}
+	}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return
+	}
+	log.Printf("Saving %d policies and %d conditions to inventory %s", len(file.Policies), len(file.Conditions), filename)
+	return os.WriteFile(filename, b, 0644)
+}
+
+// Load an inventory file in place of querying NerdGraph
+func loadInventory(filename string) (inventory Inventory, err error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var file InventoryFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		return inventory, fmt.Errorf("invalid inventory %s: %v", filename, err)
+	}
+	if file.Version != InventoryVersion {
+		return inventory, fmt.Errorf("inventory %s has version %d, expected %d", filename, file.Version, InventoryVersion)
+	}
+	inventory = Inventory{
+		AccountId:    file.AccountId,
+		PolicyMap:    make(map[int]Policy),
+		ConditionMap: make(map[int]Condition),
+		tagsFetched:  true,
+	}
+	if file.Consistency != nil {
+		inventory.Consistency = *file.Consistency
+	}
+	for _, condition := range file.Conditions {
+		var id int
+		if id, err = strconv.Atoi(condition.Id); err != nil {
+			return inventory, fmt.Errorf("invalid condition id in %s: %q", filename, condition.Id)
+		}
+		inventory.ConditionMap[id] = condition
+	}
+	for _, policy := range file.Policies {
+		var id int
+		if id, err = strconv.Atoi(policy.Id); err != nil {
+			return inventory, fmt.Errorf("invalid policy id in %s: %q", filename, policy.Id)
+		}
+		for _, conditionId := range policy.ConditionIds {
+			if _, ok := inventory.ConditionMap[conditionId]; !ok {
+				return inventory, fmt.Errorf("policy %s in %s lists unknown condition %d", policy.Id, filename, conditionId)
+			}
+		}
+		inventory.PolicyMap[id] = policy
+		inventory.PolicyIds = append(inventory.PolicyIds, id)
+	}
+	sort.Ints(inventory.PolicyIds)
+	log.Printf("Loaded %d policies and %d conditions for account %d from inventory %s, fetched %s",
+		len(inventory.PolicyMap), len(inventory.ConditionMap), inventory.AccountId, filename, file.FetchedAt.Format(time.RFC3339))
+	return
+}
+
+// Whether an output mode that works from the inventory alone was requested,
+// rather than a Terraform scrape
+func (data *LocalData) offlineOutput() bool {
+	return len(data.ManagedDir) > 0 || !data.onlyManaged()
+}
+
+// Whether -managed is the only report or output asked for, if any
+func (data *LocalData) onlyManaged() bool {
+	return !(len(data.DriftDir) > 0 || data.Lint || data.CheckConsistency || data.CSVonly || len(data.ExportFile) > 0 ||
+		len(data.HTMLDir) > 0 || len(data.RunbookDir) > 0 || len(data.ImportFile) > 0 || data.Native)
+}
+
+// Exit status of several reports: 1 if any failed, otherwise 2 if any found
+// problems
+func worstStatus(a, b int) int {
+	if a == 1 || b == 1 {
+		return 1
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
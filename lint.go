@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lint severities, from most to least severe
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+var severityRank = map[string]int{SeverityError: 3, SeverityWarning: 2, SeverityInfo: 1}
+
+// Threshold durations below this many seconds are reported as very short
+const LintMinDuration = 120
+
+// A lint rule, checking one policy at a time
+type LintRule struct {
+	Id          string
+	Severity    string
+	Description string
+	check       func(lint *LintReport, data *LocalData, policy Policy)
+}
+
+// Every lint rule, in the order -list-lint-rules shows them
+var LintRules = []LintRule{
+	{"empty-policy", SeverityWarning, "Policy has no conditions", lintEmptyPolicy},
+	{"long-disabled", SeverityInfo, "Condition has been disabled for longer than -lint-disabled-days", lintLongDisabled},
+	{"duplicate-name", SeverityWarning, "Several conditions in a policy have the same name", lintDuplicateName},
+	{"unfiltered-high-volume", SeverityWarning, "NRQL on a high volume event type has no WHERE clause", lintUnfiltered},
+	{"time-clause", SeverityError, "NRQL has SINCE, UNTIL or LIMIT, which alert queries do not support", lintTimeClause},
+	{"short-duration", SeverityWarning, fmt.Sprintf("Threshold duration is under %d seconds", LintMinDuration), lintShortDuration},
+	{"high-cardinality-facet", SeverityWarning, "NRQL facets on an attribute likely to have many values", lintFacet},
+}
+
+// Event types with enough data that an alert on all of it is rarely meant
+var highVolumeEventTypes = map[string]bool{
+	"transaction": true, "transactionerror": true, "span": true, "log": true, "metric": true,
+	"pageview": true, "pageaction": true, "browserinteraction": true, "ajaxrequest": true,
+	"systemsample": true, "processsample": true, "networksample": true, "storagesample": true,
+	"k8scontainersample": true, "k8spodsample": true,
+}
+
+var (
+	nrqlStrings       = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	nrqlFrom          = regexp.MustCompile(`(?i)\bFROM\s+([\w.:]+(?:\s*,\s*[\w.:]+)*)`)
+	nrqlWhere         = regexp.MustCompile(`(?i)\bWHERE\b`)
+	nrqlTimeClause    = regexp.MustCompile(`(?i)\b(SINCE|UNTIL|LIMIT)\b`)
+	nrqlFacet         = regexp.MustCompile(`(?is)\bFACET\s+(.+?)(?:\b(?:SINCE|UNTIL|LIMIT|TIMESERIES|ORDER|WHERE|COMPARE|WITH|SLIDE)\b|$)`)
+	highCardinality   = regexp.MustCompile(`(?i)(id|guid|uuid)$|session|token|url|uri|message|timestamp`)
+	lowCardinalityIds = map[string]bool{"accountid": true, "appid": true}
+)
+
+// A lint finding, for a policy or one of its conditions
+type LintFinding struct {
+	Rule          string `json:"rule"`
+	Severity      string `json:"severity"`
+	PolicyId      string `json:"policyId"`
+	PolicyName    string `json:"policyName"`
+	ConditionId   string `json:"conditionId,omitempty"`
+	ConditionName string `json:"conditionName,omitempty"`
+	Message       string `json:"message"`
+}
+type LintReport struct {
+	Findings []LintFinding  `json:"findings"`
+	Counts   map[string]int `json:"counts"`
+	rule     LintRule
+	now      time.Time
+	days     int
+}
+
+func (lint *LintReport) add(policy Policy, condition *Condition, format string, args ...interface{}) {
+	finding := LintFinding{
+		Rule:       lint.rule.Id,
+		Severity:   lint.rule.Severity,
+		PolicyId:   policy.Id,
+		PolicyName: policy.Name,
+		Message:    fmt.Sprintf(format, args...),
+	}
+	if condition != nil {
+		finding.ConditionId = condition.Id
+		finding.ConditionName = condition.Name
+	}
+	lint.Findings = append(lint.Findings, finding)
+	lint.Counts[finding.Severity]++
+}
+
+// Run the policy's NRQL conditions through a check of their query, with
+// string literals blanked out so their contents do not match keywords
+func (lint *LintReport) eachQuery(data *LocalData, policy Policy, check func(condition *Condition, query string)) {
+	for _, conditionId := range policy.ConditionIds {
+		condition := data.ConditionMap[conditionId]
+		if len(condition.Query) > 0 {
+			check(&condition, nrqlStrings.ReplaceAllString(condition.Query, "''"))
+		}
+	}
+}
+
+func lintEmptyPolicy(lint *LintReport, data *LocalData, policy Policy) {
+	if len(policy.ConditionIds) == 0 {
+		lint.add(policy, nil, "policy has no conditions")
+	}
+}
+
+func lintLongDisabled(lint *LintReport, data *LocalData, policy Policy) {
+	for _, conditionId := range policy.ConditionIds {
+		condition := data.ConditionMap[conditionId]
+		if condition.Enabled || condition.UpdatedAt == 0 {
+			continue
+		}
+		updated := time.UnixMilli(condition.UpdatedAt)
+		if days := int(lint.now.Sub(updated).Hours() / 24); days > lint.days {
+			lint.add(policy, &condition, "disabled and unchanged for %d days, since %s", days, updated.Format("2006-01-02"))
+		}
+	}
+}
+
+func lintDuplicateName(lint *LintReport, data *LocalData, policy Policy) {
+	seen := make(map[string]string)
+	for _, conditionId := range policy.ConditionIds {
+		condition := data.ConditionMap[conditionId]
+		if first, ok := seen[condition.Name]; ok {
+			lint.add(policy, &condition, "same name as condition %s", first)
+			continue
+		}
+		seen[condition.Name] = condition.Id
+	}
+}
+
+func lintUnfiltered(lint *LintReport, data *LocalData, policy Policy) {
+	lint.eachQuery(data, policy, func(condition *Condition, query string) {
+		match := nrqlFrom.FindStringSubmatch(query)
+		if match == nil || nrqlWhere.MatchString(query) {
+			return
+		}
+		for _, eventType := range strings.Split(match[1], ",") {
+			eventType = strings.TrimSpace(eventType)
+			if highVolumeEventTypes[strings.ToLower(eventType)] {
+				lint.add(policy, condition, "queries all of %s without a WHERE clause", eventType)
+				return
+			}
+		}
+	})
+}
+
+func lintTimeClause(lint *LintReport, data *LocalData, policy Policy) {
+	lint.eachQuery(data, policy, func(condition *Condition, query string) {
+		if match := nrqlTimeClause.FindString(query); len(match) > 0 {
+			lint.add(policy, condition, "query has %s, which is not supported in alert conditions", strings.ToUpper(match))
+		}
+	})
+}
+
+func lintShortDuration(lint *LintReport, data *LocalData, policy Policy) {
+	for _, conditionId := range policy.ConditionIds {
+		condition := data.ConditionMap[conditionId]
+		for _, t := range []struct {
+			priority  string
+			threshold Threshold
+		}{{"critical", condition.Critical}, {"warning", condition.Warning}} {
+			duration := t.threshold.ThresholdDuration
+			if len(t.threshold.Operator) > 0 && duration > 0 && duration < LintMinDuration {
+				lint.add(policy, &condition, "%s threshold duration is only %d seconds", t.priority, duration)
+			}
+		}
+	}
+}
+
+func lintFacet(lint *LintReport, data *LocalData, policy Policy) {
+	lint.eachQuery(data, policy, func(condition *Condition, query string) {
+		match := nrqlFacet.FindStringSubmatch(query)
+		if match == nil {
+			return
+		}
+		for _, attribute := range strings.Split(match[1], ",") {
+			attribute = strings.Trim(strings.TrimSpace(attribute), "`")
+			if highCardinality.MatchString(attribute) && !lowCardinalityIds[strings.ToLower(attribute)] {
+				lint.add(policy, condition, "facets on %s, which is likely to have many values", attribute)
+			}
+		}
+	})
+}
+
+// Print the rules for -list-lint-rules
+func listLintRules() {
+	for _, rule := range LintRules {
+		fmt.Printf("%-24s %-8s %s\n", rule.Id, rule.Severity, rule.Description)
+	}
+}
+
+// Run the lint rules not skipped with -lint-skip over every policy
+func (data *LocalData) lintReport() (lint LintReport, err error) {
+	skip := make(map[string]bool)
+	for _, id := range strings.Split(data.LintSkip, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			skip[id] = true
+		}
+	}
+	for id := range skip {
+		var found bool
+		for _, rule := range LintRules {
+			found = found || rule.Id == id
+		}
+		if !found {
+			return lint, fmt.Errorf("unknown lint rule %q, see -list-lint-rules", id)
+		}
+	}
+
+	lint = LintReport{Findings: []LintFinding{}, Counts: make(map[string]int), now: time.Now(), days: data.LintDisabledDays}
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		for _, rule := range LintRules {
+			if !skip[rule.Id] {
+				lint.rule = rule
+				rule.check(&lint, data, policy)
+			}
+		}
+	}
+	return
+}
+
+// Print the findings as text or JSON
+func (lint LintReport) print(asJSON bool) {
+	if asJSON {
+		b, _ := json.MarshalIndent(lint, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	for _, finding := range lint.Findings {
+		target := fmt.Sprintf("policy %s %q", finding.PolicyId, finding.PolicyName)
+		if len(finding.ConditionId) > 0 {
+			target += fmt.Sprintf(" condition %s %q", finding.ConditionId, finding.ConditionName)
+		}
+		fmt.Printf("%s %s %s: %s\n", strings.ToUpper(finding.Severity), finding.Rule, target, finding.Message)
+	}
+	var counts []string
+	for _, severity := range []string{SeverityError, SeverityWarning, SeverityInfo} {
+		counts = append(counts, strconv.Itoa(lint.Counts[severity])+" "+severity)
+	}
+	fmt.Printf("Lint: %d findings (%s)\n", len(lint.Findings), strings.Join(counts, ", "))
+}
+
+// Report lint findings, with exit status 2 when any are at least as severe
+// as -lint-fail
+func (data *LocalData) reportLint() int {
+	failRank, ok := severityRank[data.LintFail]
+	if !ok && data.LintFail != "none" {
+		log.Printf("Invalid -lint-fail %q, use error, warning, info or none", data.LintFail)
+		return 1
+	}
+	lint, err := data.lintReport()
+	if err != nil {
+		log.Printf("Error running lint: %v", err)
+		return 1
+	}
+	sort.SliceStable(lint.Findings, func(i, j int) bool {
+		return severityRank[lint.Findings[i].Severity] > severityRank[lint.Findings[j].Severity]
+	})
+	lint.print(data.JSONOutput)
+	for _, finding := range lint.Findings {
+		if ok && severityRank[finding.Severity] >= failRank {
+			return 2
+		}
+	}
+	return 0
+}
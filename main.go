@@ -7,22 +7,74 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type LocalData struct {
-	AccountId      int
-	UserKey        string
-	Concurrent     int
-	CSVonly        bool
-	Disable        bool
-	Client         *http.Client
-	GraphQlHeaders []string
-	CDPctx         context.Context
-	CDPcancel      context.CancelFunc
-	PolicyIds      []int
-	PolicyMap      map[int]Policy
-	ConditionMap   map[int]Condition
-	Dump           string
+	Inventory
+	UserKey          string
+	Concurrent       int
+	CSVonly          bool
+	ColumnNames      string
+	Columns          []CSVColumn
+	Disable          bool
+	ManagedDir       string
+	UnmanagedOnly    bool
+	DriftDir         string
+	JSONOutput       bool
+	InventoryFile    string
+	SaveInventory    string
+	Native           bool
+	NativeDir        string
+	ExportFile       string
+	ExportFormat     string
+	HTMLDir          string
+	RunbookDir       string
+	ImportFile       string
+	Apply            bool
+	Lint             bool
+	LintSkip         string
+	LintFail         string
+	LintDisabledDays int
+	CheckConsistency bool
+	IncludeOrphans   bool
+	Headless         bool
+	SessionFile      string
+	RemoteURL        string
+	ChromePath       string
+	UserDataDir      string
+	Proxy            string
+	WindowSize       string
+	WindowWidth      int
+	WindowHeight     int
+	Retries          int
+	StepTimeout      time.Duration
+	DiagnosticsDir   string
+	RerunFailures    bool
+	RerunIds         map[int]bool
+	Resume           bool
+	Checkpoint       *Checkpoint
+	Failures         []ScrapeFailure
+	failureLock      sync.Mutex
+	SelectorsFile    string
+	SelectorProfile  string
+	Profile          *SelectorProfile
+	DebugSource      bool
+	LoginURL         string
+	NR1URL           string
+	LogoutURL        string
+	TraceFile        string
+	Tracer           *Tracer
+	ShowProgress     bool
+	Progress         *Progress
+	Client           *http.Client
+	GraphQlHeaders   []string
+	Ctx              context.Context
+	AllocCancel      context.CancelFunc
+	CDPctx           context.Context
+	CDPcancel        context.CancelFunc
+	Dump             string
 }
 
 func main() {
@@ -32,18 +84,80 @@ func main() {
 	data := LocalData{
 		UserKey:    os.Getenv("NEW_RELIC_USER_KEY"),
 		Concurrent: 1,
+		Ctx:        context.Background(),
 	}
 
 	// Get commandline options
 	flag.BoolVar(&data.CSVonly, "csv", false, "Generate CSV mode")
+	flag.StringVar(&data.ColumnNames, "columns", "", "Comma separated CSV columns, in order (default "+DefaultColumns+")")
+	var showColumns, showLintRules bool
+	flag.BoolVar(&showColumns, "list-columns", false, "List the available CSV columns and exit")
 	flag.BoolVar(&data.Disable, "disable", false, "Disable all NRQL conditions")
+	flag.StringVar(&data.ManagedDir, "managed", "", "Report alerts managed by the Terraform in this directory")
+	flag.BoolVar(&data.UnmanagedOnly, "unmanaged-only", false, "With -managed, generate output for unmanaged alerts only")
+	flag.StringVar(&data.DriftDir, "drift", "", "Report drift between the Terraform in this directory and live alerts")
+	flag.BoolVar(&data.JSONOutput, "json", false, "Print reports as JSON")
+	flag.StringVar(&data.InventoryFile, "inventory", "", "Work offline from this inventory file instead of querying NerdGraph")
+	flag.StringVar(&data.SaveInventory, "save-inventory", "", "Save the alerts fetched from NerdGraph to this inventory file")
+	flag.BoolVar(&data.Native, "native", false, "Generate Terraform from the fetched alert details instead of scraping the UI")
+	flag.StringVar(&data.NativeDir, "native-dir", "native", "Write -native Terraform to this folder, apart from scraped files")
+	flag.StringVar(&data.ExportFile, "export", "", "Export the alert inventory to this file, or - for stdout")
+	flag.StringVar(&data.ExportFormat, "export-format", "json", "Export as json, or ndjson with one condition per line")
+	flag.StringVar(&data.HTMLDir, "html", "", "Write a browsable HTML report of the alert inventory to this folder")
+	flag.StringVar(&data.RunbookDir, "runbooks", "", "Write a Markdown runbook per policy to this folder, keeping notes in existing ones")
+	flag.StringVar(&data.ImportFile, "import", "", "Show the changes to condition names, enabled states and NRQL made in this edited CSV")
+	flag.BoolVar(&data.Apply, "apply", false, "Make the changes shown by -import")
+	flag.BoolVar(&data.Lint, "lint", false, "Check the alert inventory for common problems")
+	flag.BoolVar(&showLintRules, "list-lint-rules", false, "List the lint rules and exit")
+	flag.StringVar(&data.LintSkip, "lint-skip", "", "Comma separated lint rules not to run")
+	flag.StringVar(&data.LintFail, "lint-fail", SeverityWarning, "Exit with status 2 on lint findings of this severity or worse: error, warning, info or none")
+	flag.IntVar(&data.LintDisabledDays, "lint-disabled-days", 30, "Days a condition may stay disabled before lint reports it")
+	flag.BoolVar(&data.CheckConsistency, "consistency", false, "Report orphaned conditions, malformed entities, unindexed policies and missing condition details")
+	flag.BoolVar(&data.IncludeOrphans, "include-orphans", false, "Include conditions whose policy was not found in outputs, under a policy named \""+UnassignedPolicyName+"\"")
+	flag.BoolVar(&data.Headless, "headless", false, "Run Chrome headless, using the session saved with -session")
+	flag.StringVar(&data.RemoteURL, "remote", "", "Attach to a running Chrome at this remote debugging URL, e.g. http://127.0.0.1:9222")
+	flag.StringVar(&data.ChromePath, "chrome", "", "Chrome or Chromium binary to launch, instead of the one found on the PATH")
+	flag.StringVar(&data.UserDataDir, "user-data-dir", "", "Chrome profile directory, instead of a temporary one")
+	flag.StringVar(&data.Proxy, "proxy", "", "Proxy server for Chrome, e.g. http://proxy.example.com:8080")
+	flag.StringVar(&data.WindowSize, "window-size", "", "Size of each Chrome window, e.g. 1280x800")
+	flag.StringVar(&data.SessionFile, "session", "", "File to save the browser session to after login, and reuse it from")
+	flag.IntVar(&data.Retries, "retries", 2, "Reload and retry a failed condition scrape this many times")
+	flag.DurationVar(&data.StepTimeout, "step-timeout", 30*time.Second, "Timeout for each condition scrape step")
+	flag.StringVar(&data.DiagnosticsDir, "diagnostics", "diagnostics", "Folder for screenshots and DOM of failed condition scrapes")
+	flag.StringVar(&data.SelectorsFile, "selectors", "", "JSON file of UI selector profiles, overriding the built in ones")
+	flag.StringVar(&data.SelectorProfile, "ui-profile", "", "Name of the UI selector profile to use")
+	flag.BoolVar(&data.DebugSource, "debug-source", false, "Log whether each condition's Terraform came from the network or the page")
+	flag.StringVar(&data.TraceFile, "trace", "", "Write a zip of network HARs and a step timeline for each tab to this file")
+	flag.BoolVar(&data.RerunFailures, "rerun-failures", false, "Scrape only the conditions listed in "+FailuresFile+" and splice them into the policy files")
+	flag.StringVar(&data.LoginURL, "login-url", DefaultLoginURL, "New Relic login page")
+	flag.StringVar(&data.NR1URL, "nr1-url", DefaultNR1URL, "Base URL of the NR1 UI, replacing {nr1} in selector profile urls")
+	flag.StringVar(&data.LogoutURL, "logout-url", DefaultLogoutURL, "New Relic logout page")
+	flag.BoolVar(&data.ShowProgress, "progress", true, "Show progress with ETA, as a status line on a terminal or periodic log lines otherwise")
+	flag.BoolVar(&data.Resume, "resume", false, "Continue an earlier scrape from its checkpoint file, skipping completed work")
 	flag.Parse()
+	if showColumns {
+		listColumns()
+		os.Exit(0)
+	}
+	if showLintRules {
+		listLintRules()
+		os.Exit(0)
+	}
+	err = data.selectColumns()
+	if err != nil {
+		log.Printf("Invalid -columns: %v", err)
+		os.Exit(1)
+	}
 	if data.CSVonly {
 		log.Printf("CSV mode enabled")
 	}
 	if data.Disable {
 		log.Printf("Disable all NRQL conditions")
 	}
+	if data.Apply && len(data.ImportFile) == 0 {
+		log.Printf("-apply needs a CSV to import with -import")
+		os.Exit(1)
+	}
 
 	// Validate settings
 	concurrent := os.Getenv("CONCURRENT")
@@ -55,48 +169,198 @@ func main() {
 		}
 		if data.Concurrent > 20 {
 			data.Concurrent = 20
-			log.Printf("Limiting env var CONCURRENT to 20", err)
+			log.Printf("Limiting env var CONCURRENT to 20")
 		}
 	}
-	accountId := os.Getenv("NEW_RELIC_ACCOUNT")
-	if len(accountId) == 0 {
-		log.Printf("Please set env var NEW_RELIC_ACCOUNT")
-		os.Exit(1)
+	if len(data.WindowSize) > 0 {
+		data.WindowWidth, data.WindowHeight, err = parseWindowSize(data.WindowSize)
+		if err != nil {
+			log.Printf("Invalid -window-size: %v", err)
+			os.Exit(1)
+		}
 	}
-	data.AccountId, err = strconv.Atoi(accountId)
-	if err != nil {
-		log.Printf("Please set env var NEW_RELIC_ACCOUNT to an integer")
-		os.Exit(1)
+	if data.ShowProgress && data.ExportFile != "-" {
+		data.Progress = newProgress()
 	}
-	if len(data.UserKey) == 0 {
-		log.Printf("Please set env var NEW_RELIC_USER_KEY")
-		os.Exit(1)
+
+	if len(data.InventoryFile) > 0 {
+		// Work offline from a saved inventory
+		if data.Disable || data.Apply || len(data.SaveInventory) > 0 {
+			log.Printf("-inventory cannot be used with -disable, -apply or -save-inventory")
+			os.Exit(1)
+		}
+		data.Inventory, err = loadInventory(data.InventoryFile)
+		if err != nil {
+			log.Printf("Error loading inventory: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		accountId := os.Getenv("NEW_RELIC_ACCOUNT")
+		if len(accountId) == 0 {
+			log.Printf("Please set env var NEW_RELIC_ACCOUNT")
+			os.Exit(1)
+		}
+		data.AccountId, err = strconv.Atoi(accountId)
+		if err != nil {
+			log.Printf("Please set env var NEW_RELIC_ACCOUNT to an integer")
+			os.Exit(1)
+		}
+		if len(data.UserKey) == 0 {
+			log.Printf("Please set env var NEW_RELIC_USER_KEY")
+			os.Exit(1)
+		}
+		data.makeClient()
+
+		// Get list of policies
+		data.getPolicies()
+
+		// Get conditions for these
+		data.getConditions()
+		data.getConditionDetails()
+
+		// Keep them for offline runs
+		if len(data.SaveInventory) > 0 {
+			err = data.saveInventory(data.SaveInventory)
+			if err != nil {
+				log.Printf("Error saving inventory: %v", err)
+				os.Exit(1)
+			}
+			if !data.offlineOutput() {
+				os.Exit(0)
+			}
+		}
 	}
-	data.makeClient()
 
-	// Get list of policies
-	data.getPolicies()
+	data.Consistency.summary()
+	if data.IncludeOrphans {
+		data.includeOrphans()
+	}
 
-	// Get conditions for these
-	data.getConditions()
-	data.getConditionDetails()
+	// Run every report and output asked for, then exit once with the worst
+	// status. Only -unmanaged-only on its own goes on to scrape.
+	var status int
+	outputs := data.offlineOutput() && !(data.UnmanagedOnly && data.onlyManaged())
+	if len(data.DriftDir) > 0 {
+		status = worstStatus(status, data.reportDrift())
+	}
+	if data.Lint {
+		status = worstStatus(status, data.reportLint())
+	}
+	if data.CheckConsistency {
+		status = worstStatus(status, data.reportConsistency())
+	}
+	if len(data.ManagedDir) > 0 {
+		if managed := data.reportManaged(); managed != 0 {
+			status = worstStatus(status, managed)
+			outputs = true
+		}
+	}
 
 	if data.CSVonly {
-		data.writeCSV()
-		os.Exit(0)
+		if data.hasColumn("policyTags") {
+			data.getPolicyTags()
+		}
+		data.writeCSV(data.Columns)
+	}
+	if len(data.ExportFile) > 0 {
+		err = data.writeExport()
+		if err != nil {
+			log.Printf("Error exporting inventory: %v", err)
+			status = 1
+		}
+	}
+	if len(data.HTMLDir) > 0 {
+		err = data.writeHTMLReport()
+		if err != nil {
+			log.Printf("Error writing HTML report: %v", err)
+			status = 1
+		}
+	}
+	if len(data.RunbookDir) > 0 {
+		err = data.writeRunbooks()
+		if err != nil {
+			log.Printf("Error writing runbooks: %v", err)
+			status = 1
+		}
+	}
+	if len(data.ImportFile) > 0 {
+		err = data.importCSV()
+		if err != nil {
+			log.Printf("Error importing CSV: %v", err)
+			status = 1
+		}
+	}
+	if data.Native {
+		err = data.writeNativeTF(data.NativeDir)
+		if err != nil {
+			log.Printf("Error generating native Terraform: %v", err)
+			status = 1
+		}
+	}
+	if outputs {
+		os.Exit(status)
 	}
 
-	// Login for scraper
+	// Limit scraping to the failures of the previous run
+	if data.RerunFailures {
+		err = data.loadFailures()
+		if err != nil {
+			log.Printf("Error loading %s: %v", FailuresFile, err)
+			os.Exit(1)
+		}
+	}
+
+	// Track progress so the scrape can be resumed
+	if data.Resume {
+		if data.RerunFailures {
+			log.Printf("Use either -resume or -rerun-failures, not both")
+			os.Exit(1)
+		}
+		err = data.loadCheckpoint()
+		if err != nil {
+			log.Printf("Error loading checkpoint: %v", err)
+			os.Exit(1)
+		}
+	} else if !data.RerunFailures {
+		data.newCheckpoint()
+	}
+
+	// Load UI selectors for scraper
+	err = data.loadSelectors()
+	if err != nil {
+		log.Printf("Error loading UI selectors: %v", err)
+		os.Exit(1)
+	}
+
+	// Login for scraper, cleaning up on Ctrl-C from here on
+	data.handleSignals()
+	if len(data.TraceFile) > 0 {
+		data.Tracer = newTracer()
+	}
 	err = data.startChromeAndLogin()
 	if err != nil {
 		log.Printf("Issue loggin into NR1: %v", err)
+		data.Tracer.write(data.TraceFile)
+		data.closeChrome()
 		os.Exit(1)
 	}
 
 	// Generate Terraform and write files
-	data.walkPolicies()
+	err = data.walkPolicies()
+	if err != nil {
+		log.Printf("Stopping before scrape: %v", err)
+	}
 
 	// Exit
 	data.logout()
+	data.Tracer.write(data.TraceFile)
+	data.closeChrome()
+	if err != nil {
+		os.Exit(1)
+	}
+	if data.interrupted() {
+		log.Println("Interrupted, see " + FailuresFile + " for the conditions not scraped")
+		os.Exit(130)
+	}
 	log.Println("Done")
 }
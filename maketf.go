@@ -4,10 +4,42 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-// Generate the policy Terraform code
+// Comment that tags each scraped condition resource with its condition id, so
+// that merges and re-runs find the right block whatever its label and name
+const ConditionTag = "# alerts-tf-scrape condition %s"
+
+var conditionTagPattern = regexp.MustCompile(`#\s*alerts-tf-scrape condition (\d+)`)
+
+// Put the condition tag on the first line inside a scraped resource
+func tagCondition(text, conditionId string) string {
+	open := strings.Index(text, "{\n")
+	if open < 0 || len(conditionId) == 0 || len(conditionTagPattern.FindString(text)) > 0 {
+		return text
+	}
+	return text[:open+2] + "  " + fmt.Sprintf(ConditionTag, conditionId) + "\n" + text[open+2:]
+}
+
+// Condition id tagged in an item, if any
+func conditionTagOf(src string, item *TFItem) string {
+	if match := conditionTagPattern.FindStringSubmatch(src[item.Start:item.End]); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Generate the policy Terraform code, unless it is already managed elsewhere
+// or is the synthetic policy of orphaned conditions, which does not exist
 func (policy *Policy) makePolicyTF() {
+	if policy.Managed || policy.Id == strconv.Itoa(UnassignedPolicyId) {
+		policy.TF = ""
+		return
+	}
 	policy.TF = fmt.Sprintf(`resource "newrelic_alert_policy" "policy_%s" {
   account_id = %d
   policy_id = %s
@@ -17,25 +49,60 @@ func (policy *Policy) makePolicyTF() {
 	return
 }
 
+// Assemble the policy and its scraped conditions, in ConditionIds order,
+// noting the conditions that have no text so a merge keeps their old blocks
+func (policy *Policy) makeTF(texts map[int]string) {
+	policy.makePolicyTF()
+	policy.Failed = make(map[string]bool)
+	for _, conditionId := range policy.ConditionIds {
+		text, ok := texts[conditionId]
+		if !ok {
+			policy.Failed[strconv.Itoa(conditionId)] = true
+			continue
+		}
+		policy.TF += tagCondition(text, strconv.Itoa(conditionId))
+	}
+}
+
 // Walk the policies to scrape each condition Terraform code
-func (data *LocalData) walkPolicies() {
+func (data *LocalData) walkPolicies() (err error) {
+
+	// Check the selectors work before starting
+	if err = data.selfCheck(); err != nil {
+		return
+	}
 
 	// Traverse policies concurrently
 	log.Printf("Walking %d policies to generate Terraform", len(data.PolicyIds))
 	log.Printf("Using concurrency=%d", data.Concurrent)
 	data.concurrentScrape()
+	return
 }
 
-func (policy *Policy) writeTF() {
-	filename := fmt.Sprintf("policy_%s.tf", policy.Id)
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Printf("Error opening alert policy terraform: %v", err)
+// Write the policy Terraform to a directory, merging into any existing file
+// so hand edits survive
+func (policy *Policy) writeTF(dir string) {
+	filename := filepath.Join(dir, fmt.Sprintf("policy_%s.tf", policy.Id))
+	text := policy.TF + "\n"
+	existing, err := os.ReadFile(filename)
+	if err == nil {
+		text, err = mergeTF(string(existing), text, policy.Failed)
+		if err != nil {
+			log.Printf("Error merging into existing %s, leaving it unchanged: %v", filename, err)
+			return
+		}
+		if text == string(existing) {
+			log.Printf("No changes to alert policy terraform %s", filename)
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading alert policy terraform: %v", err)
+		return
 	}
 
 	log.Printf("Writing alert policy terraform to %s", filename)
-	f.WriteString(policy.TF)
-	f.WriteString("\n")
-	f.Sync()
-	f.Close()
+	err = os.WriteFile(filename, []byte(text), 0644)
+	if err != nil {
+		log.Printf("Error writing alert policy terraform: %v", err)
+	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Live alerts compared against an existing Terraform configuration
+type ManagedEntry struct {
+	Kind     string `json:"kind"`
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	PolicyId string `json:"policyId,omitempty"`
+	Address  string `json:"address,omitempty"`
+	File     string `json:"file,omitempty"`
+}
+type ManagedReport struct {
+	Directory string         `json:"directory"`
+	Managed   []ManagedEntry `json:"managed"`
+	Unmanaged []ManagedEntry `json:"unmanaged"`
+	Dangling  []ManagedEntry `json:"dangling"`
+}
+
+// Match Terraform resources to live policies and conditions. Import blocks
+// win, then literal ids, then names that are unique in their scope.
+func (data *LocalData) matchTFConfig(config *TFConfig) {
+	policyNames := make(map[string][]int)
+	for _, policyId := range data.PolicyIds {
+		name := data.PolicyMap[policyId].Name
+		policyNames[name] = append(policyNames[name], policyId)
+	}
+	for _, r := range config.Policies {
+		if id, ok := config.importedId(r); ok {
+			r.LiveId = liveId(id, data.PolicyMap[id].Id)
+			continue
+		}
+		if value, ok := config.attr(r, "policy_id"); ok {
+			id, _ := strconv.Atoi(value)
+			r.LiveId = liveId(id, data.PolicyMap[id].Id)
+			continue
+		}
+		if name, ok := config.attr(r, "name"); ok && len(policyNames[name]) == 1 {
+			r.LiveId = policyNames[name][0]
+		}
+	}
+
+	// Policy resources by address, to follow policy_id references
+	policyAddresses := make(map[string]int)
+	for _, r := range config.Policies {
+		policyAddresses[r.Address()+".id"] = r.LiveId
+	}
+	conditionNames := make(map[string][]int)
+	for id, condition := range data.ConditionMap {
+		conditionNames[condition.Name] = append(conditionNames[condition.Name], id)
+	}
+	for _, r := range config.Conditions {
+		if id, ok := config.importedId(r); ok {
+			r.LiveId = liveId(id, data.ConditionMap[id].Id)
+			continue
+		}
+		name, ok := config.attr(r, "name")
+		if !ok {
+			continue
+		}
+		policyId := policyAddresses[strings.TrimSpace(r.Item.Attr("policy_id"))]
+		if value, ok := config.attr(r, "policy_id"); ok {
+			policyId, _ = strconv.Atoi(value)
+		}
+		candidates := conditionNames[name]
+		if policyId != 0 {
+			candidates = nil
+			for _, id := range data.PolicyMap[policyId].ConditionIds {
+				if data.ConditionMap[id].Name == name {
+					candidates = append(candidates, id)
+				}
+			}
+		}
+		if len(candidates) == 1 {
+			r.LiveId = candidates[0]
+		}
+	}
+}
+
+// Only ids that exist in the live account count as a match
+func liveId(id int, found string) int {
+	if len(found) == 0 {
+		return 0
+	}
+	return id
+}
+
+// Compare live alerts with the Terraform in dir
+func (data *LocalData) managedReport(dir string) (report ManagedReport, err error) {
+	var config *TFConfig
+	config, err = loadTFDir(dir)
+	if err != nil {
+		return
+	}
+	log.Printf("Found %d policy and %d condition resources in %s", len(config.Policies), len(config.Conditions), dir)
+	data.matchTFConfig(config)
+
+	report.Directory = dir
+	managedPolicies := make(map[int]*TFResource)
+	for _, r := range config.Policies {
+		if r.LiveId == 0 {
+			name, _ := config.attr(r, "name")
+			report.Dangling = append(report.Dangling, ManagedEntry{Kind: "policy", Name: name, Address: r.Address(), File: r.File})
+			continue
+		}
+		managedPolicies[r.LiveId] = r
+	}
+	managedConditions := make(map[int]*TFResource)
+	for _, r := range config.Conditions {
+		if r.LiveId == 0 {
+			name, _ := config.attr(r, "name")
+			report.Dangling = append(report.Dangling, ManagedEntry{Kind: "condition", Name: name, Address: r.Address(), File: r.File})
+			continue
+		}
+		managedConditions[r.LiveId] = r
+	}
+
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		entry := ManagedEntry{Kind: "policy", Id: policy.Id, Name: policy.Name}
+		if r, ok := managedPolicies[policyId]; ok {
+			entry.Address, entry.File = r.Address(), r.File
+			report.Managed = append(report.Managed, entry)
+			policy.Managed = true
+		} else {
+			report.Unmanaged = append(report.Unmanaged, entry)
+		}
+		for _, conditionId := range policy.ConditionIds {
+			condition := data.ConditionMap[conditionId]
+			entry := ManagedEntry{Kind: "condition", Id: condition.Id, Name: condition.Name, PolicyId: policy.Id}
+			if r, ok := managedConditions[conditionId]; ok {
+				entry.Address, entry.File = r.Address(), r.File
+				report.Managed = append(report.Managed, entry)
+				condition.Managed = true
+			} else {
+				report.Unmanaged = append(report.Unmanaged, entry)
+			}
+			data.ConditionMap[conditionId] = condition
+		}
+		data.PolicyMap[policyId] = policy
+	}
+	return
+}
+
+// Print the report as text or JSON
+func (report ManagedReport) print(asJSON bool) {
+	if asJSON {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Printf("Terraform in %s: %d managed, %d unmanaged, %d dangling\n",
+		report.Directory, len(report.Managed), len(report.Unmanaged), len(report.Dangling))
+	for _, section := range []struct {
+		title   string
+		entries []ManagedEntry
+	}{
+		{"MANAGED", report.Managed},
+		{"UNMANAGED", report.Unmanaged},
+		{"DANGLING", report.Dangling},
+	} {
+		for _, entry := range section.entries {
+			line := fmt.Sprintf("%-10s %-9s %-10s %q", section.title, entry.Kind, entry.Id, entry.Name)
+			if len(entry.PolicyId) > 0 {
+				line += " policy " + entry.PolicyId
+			}
+			if len(entry.Address) > 0 {
+				line += fmt.Sprintf(" %s (%s)", entry.Address, entry.File)
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// Restrict the policies and conditions to those not yet managed in Terraform
+func (data *LocalData) keepUnmanaged() {
+	var policyIds []int
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		var conditionIds []int
+		for _, conditionId := range policy.ConditionIds {
+			if !data.ConditionMap[conditionId].Managed {
+				conditionIds = append(conditionIds, conditionId)
+			}
+		}
+		policy.ConditionIds = conditionIds
+		data.PolicyMap[policyId] = policy
+		if !policy.Managed || len(conditionIds) > 0 {
+			policyIds = append(policyIds, policyId)
+		}
+	}
+	log.Printf("Generating Terraform for %d policies with unmanaged alerts", len(policyIds))
+	data.PolicyIds = policyIds
+}
+
+// Report on managed alerts, optionally narrowing generation to unmanaged ones
+func (data *LocalData) reportManaged() int {
+	report, err := data.managedReport(data.ManagedDir)
+	if err != nil {
+		log.Printf("Error reading Terraform in %s: %v", data.ManagedDir, err)
+		return 1
+	}
+	report.print(data.JSONOutput)
+	if data.UnmanagedOnly {
+		data.keepUnmanaged()
+	}
+	return 0
+}
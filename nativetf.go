@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Quote a string for HCL, escaping template sequences
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return strings.NewReplacer("${", "$${", "%{", "%%{").Replace(b.String())
+}
+
+// Generate a condition's Terraform from its fetched details instead of
+// scraping the UI. Conditions without NRQL details cannot be generated.
+func (inventory *Inventory) conditionTF(policy Policy, condition Condition) (string, bool) {
+	if len(condition.NrqlType) == 0 || len(condition.Query) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	attr := func(indent, key, value string) {
+		fmt.Fprintf(&b, "%s%s = %s\n", indent, key, value)
+	}
+	fmt.Fprintf(&b, "resource %q \"condition_%s\" {\n", TFConditionType, condition.Id)
+	attr("  ", "account_id", strconv.Itoa(condition.AccountId))
+	switch {
+	case policy.Id == strconv.Itoa(UnassignedPolicyId):
+		attr("  ", "policy_id", inventory.Consistency.orphanPolicyId(condition.Id))
+	case policy.Managed:
+		attr("  ", "policy_id", policy.Id)
+	default:
+		attr("  ", "policy_id", fmt.Sprintf("%s.policy_%s.id", TFPolicyType, policy.Id))
+	}
+	attr("  ", "type", hclString(strings.ToLower(condition.NrqlType)))
+	attr("  ", "name", hclString(condition.Name))
+	if len(condition.Description) > 0 {
+		attr("  ", "description", hclString(condition.Description))
+	}
+	if len(condition.RunbookURL) > 0 {
+		attr("  ", "runbook_url", hclString(condition.RunbookURL))
+	}
+	attr("  ", "enabled", strconv.FormatBool(condition.Enabled))
+	if len(condition.BaselineDirection) > 0 {
+		attr("  ", "baseline_direction", hclString(strings.ToLower(condition.BaselineDirection)))
+	}
+	b.WriteString("\n  nrql {\n")
+	attr("    ", "query", hclString(condition.Query))
+	b.WriteString("  }\n")
+
+	for _, term := range condition.Terms {
+		block := "critical"
+		if term.Priority == "WARNING" {
+			block = "warning"
+		}
+		fmt.Fprintf(&b, "\n  %s {\n", block)
+		attr("    ", "operator", hclString(strings.ToLower(term.Operator)))
+		attr("    ", "threshold", strconv.FormatFloat(term.Threshold, 'f', -1, 64))
+		attr("    ", "threshold_duration", strconv.Itoa(term.ThresholdDuration))
+		attr("    ", "threshold_occurrences", hclString(strings.ToLower(term.ThresholdOccurrences)))
+		b.WriteString("  }\n")
+	}
+
+	b.WriteString("\n")
+	if len(condition.Fill) > 0 {
+		attr("  ", "fill_option", hclString(strings.ToLower(condition.Fill)))
+	}
+	if condition.FillValue != nil {
+		attr("  ", "fill_value", strconv.FormatFloat(*condition.FillValue, 'f', -1, 64))
+	}
+	if condition.AggWindow > 0 {
+		attr("  ", "aggregation_window", strconv.Itoa(condition.AggWindow))
+	}
+	if len(condition.AggMethod) > 0 {
+		attr("  ", "aggregation_method", hclString(strings.ToLower(condition.AggMethod)))
+	}
+	for _, setting := range []struct {
+		key   string
+		value *int
+	}{
+		{"aggregation_delay", condition.AggDelay},
+		{"aggregation_timer", condition.AggTimer},
+		{"slide_by", condition.SlideBy},
+		{"evaluation_delay", condition.EvaluationDelay},
+	} {
+		if setting.value != nil {
+			attr("  ", setting.key, strconv.Itoa(*setting.value))
+		}
+	}
+	if condition.ViolationLimit > 0 {
+		attr("  ", "violation_time_limit_seconds", strconv.Itoa(condition.ViolationLimit))
+	}
+	if condition.Expiration.ExpirationDuration != nil {
+		attr("  ", "expiration_duration", strconv.Itoa(*condition.Expiration.ExpirationDuration))
+	}
+	attr("  ", "open_violation_on_expiration", strconv.FormatBool(condition.Expiration.OpenViolationOnExpiration))
+	attr("  ", "close_violations_on_expiration", strconv.FormatBool(condition.Expiration.CloseViolationsOnExpiration))
+	b.WriteString("}\n\n")
+	return b.String(), true
+}
+
+// Write policy files with natively generated conditions to their own
+// directory, without a browser. Native resources are labelled by condition
+// id, so they must not be merged into scraped files.
+func (inventory *Inventory) writeNativeTF(dir string) (err error) {
+	if filepath.Clean(dir) == "." {
+		return fmt.Errorf("-native-dir must not be the working directory, where scraped files are written")
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	var generated, skipped int
+	for _, policyId := range inventory.PolicyIds {
+		policy := inventory.PolicyMap[policyId]
+		texts := make(map[int]string)
+		for _, conditionId := range policy.ConditionIds {
+			condition := inventory.ConditionMap[conditionId]
+			text, ok := inventory.conditionTF(policy, condition)
+			if !ok {
+				log.Printf("Condition %s %q (%s) has no NRQL details, not generated", condition.Id, condition.Name, condition.Type)
+				skipped++
+				continue
+			}
+			texts[conditionId] = text
+			generated++
+		}
+		policy.makeTF(texts)
+		policy.TF = strings.TrimRight(policy.TF, "\n")
+		policy.writeTF(dir)
+	}
+	log.Printf("Generated %d conditions natively to %s, skipped %d", generated, dir, skipped)
+	return
+}
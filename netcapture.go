@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Marks the start of generated Terraform in a response payload
+const TFMarker = `resource "newrelic_`
+
+// Records the API responses a tab receives, so the Terraform code preview
+// can be taken from the payload the UI renders it from
+type NetCapture struct {
+	lock     sync.Mutex
+	pending  map[network.RequestID]bool
+	finished []network.RequestID
+}
+
+func listenNetwork(ctx context.Context) (capture *NetCapture) {
+	capture = &NetCapture{pending: make(map[network.RequestID]bool)}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventResponseReceived:
+			if ev.Type == network.ResourceTypeXHR || ev.Type == network.ResourceTypeFetch {
+				capture.lock.Lock()
+				capture.pending[ev.RequestID] = true
+				capture.lock.Unlock()
+			}
+		case *network.EventLoadingFinished:
+			capture.lock.Lock()
+			if capture.pending[ev.RequestID] {
+				delete(capture.pending, ev.RequestID)
+				capture.finished = append(capture.finished, ev.RequestID)
+			}
+			capture.lock.Unlock()
+		}
+	})
+	return
+}
+
+// Forget responses from the previous condition
+func (capture *NetCapture) reset() {
+	if capture == nil {
+		return
+	}
+	capture.lock.Lock()
+	defer capture.lock.Unlock()
+	capture.pending = make(map[network.RequestID]bool)
+	capture.finished = nil
+}
+
+// Find Terraform in the captured responses, preferring the most recent
+func (capture *NetCapture) terraform(ctx context.Context) (text string, ok bool) {
+	if capture == nil {
+		return
+	}
+	capture.lock.Lock()
+	requests := append([]network.RequestID(nil), capture.finished...)
+	capture.lock.Unlock()
+
+	for i := len(requests) - 1; i >= 0; i-- {
+		body, err := network.GetResponseBody(requests[i]).Do(ctx)
+		if err != nil || !strings.Contains(string(body), TFMarker) {
+			continue
+		}
+		var payload interface{}
+		if json.Unmarshal(body, &payload) != nil {
+			return string(body), true
+		}
+		if text = findTerraform(payload); len(text) > 0 {
+			return text, true
+		}
+	}
+	return
+}
+
+// Longest string value in a JSON document that holds Terraform
+func findTerraform(value interface{}) (text string) {
+	switch value := value.(type) {
+	case string:
+		if strings.Contains(value, TFMarker) {
+			return value
+		}
+	case []interface{}:
+		for _, item := range value {
+			if found := findTerraform(item); len(found) > len(text) {
+				text = found
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range value {
+			if found := findTerraform(item); len(found) > len(text) {
+				text = found
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the progress line is redrawn on a terminal, and how often a
+// summary is logged otherwise
+const (
+	ProgressRedraw   = 500 * time.Millisecond
+	ProgressInterval = 30 * time.Second
+)
+
+// Progress of the current stage: policies, conditions, details or scrape.
+// On a terminal a status line is kept below the log, otherwise a summary
+// line is logged periodically.
+type Progress struct {
+	lock    sync.Mutex
+	tty     bool
+	out     io.Writer
+	drawn   bool
+	stage   string
+	total   int
+	done    int
+	failed  int
+	active  int
+	windows int
+	start   time.Time
+	logged  int
+}
+
+func newProgress() *Progress {
+	progress := &Progress{out: os.Stdout}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		progress.tty = true
+		log.SetOutput(progressLog{progress: progress, out: log.Writer()})
+	}
+	go progress.run()
+	return progress
+}
+
+// Start a stage, with a total of zero when it is not known yet
+func (progress *Progress) begin(stage string, total int) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.stage = stage
+	progress.total = total
+	progress.done, progress.failed, progress.active, progress.windows, progress.logged = 0, 0, 0, 0, 0
+	progress.start = time.Now()
+	progress.drawLocked()
+}
+
+func (progress *Progress) setTotal(total int) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.total = total
+}
+
+func (progress *Progress) setWindows(windows int) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.windows = windows
+}
+
+// Count items done, and those of them that failed
+func (progress *Progress) add(done int) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.done += done
+}
+func (progress *Progress) fail() {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.done++
+	progress.failed++
+}
+
+// Count windows busy scraping
+func (progress *Progress) busy(delta int) {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	defer progress.lock.Unlock()
+	progress.active += delta
+}
+
+// End the stage with a summary line
+func (progress *Progress) finish() {
+	if progress == nil {
+		return
+	}
+	progress.lock.Lock()
+	if progress.drawn {
+		fmt.Fprint(progress.out, "\r\033[K")
+		progress.drawn = false
+	}
+	status := progress.statusLocked(true)
+	progress.stage = ""
+	progress.lock.Unlock()
+	log.Printf("Finished %s", status)
+}
+
+func (progress *Progress) run() {
+	interval := ProgressInterval
+	if progress.tty {
+		interval = ProgressRedraw
+	}
+	for range time.Tick(interval) {
+		progress.lock.Lock()
+		if progress.tty {
+			progress.drawLocked()
+			progress.lock.Unlock()
+			continue
+		}
+		var status string
+		if len(progress.stage) > 0 && progress.done != progress.logged {
+			status = progress.statusLocked(false)
+			progress.logged = progress.done
+		}
+		progress.lock.Unlock()
+		if len(status) > 0 {
+			log.Printf("Progress: %s", status)
+		}
+	}
+}
+
+// Stage, done/total, rate, ETA, failures and windows busy, or for a
+// finished stage just the counts, rate and time taken
+func (progress *Progress) statusLocked(finished bool) string {
+	elapsed := time.Since(progress.start)
+	parts := []string{progress.stage}
+	if progress.total > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d (%d%%)", progress.done, progress.total, 100*progress.done/progress.total))
+	} else {
+		parts = append(parts, fmt.Sprintf("%d", progress.done))
+	}
+	var rate float64
+	if elapsed >= time.Second {
+		rate = float64(progress.done) / elapsed.Seconds()
+		parts = append(parts, fmt.Sprintf("%.1f/s", rate))
+	}
+	if !finished && progress.total > progress.done && rate > 0 {
+		eta := time.Duration(float64(progress.total-progress.done) / rate * float64(time.Second))
+		parts = append(parts, "ETA "+eta.Round(time.Second).String())
+	}
+	if progress.failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", progress.failed))
+	}
+	if !finished && progress.windows > 0 {
+		parts = append(parts, fmt.Sprintf("windows %d/%d", progress.active, progress.windows))
+	}
+	parts = append(parts, "elapsed "+elapsed.Round(time.Second).String())
+	return strings.Join(parts, "  ")
+}
+
+func (progress *Progress) drawLocked() {
+	if !progress.tty || len(progress.stage) == 0 {
+		return
+	}
+	fmt.Fprint(progress.out, "\r\033[K"+progress.statusLocked(false))
+	progress.drawn = true
+}
+
+// Log output that clears the progress line first and redraws it after
+type progressLog struct {
+	progress *Progress
+	out      io.Writer
+}
+
+func (w progressLog) Write(b []byte) (int, error) {
+	w.progress.lock.Lock()
+	defer w.progress.lock.Unlock()
+	if w.progress.drawn {
+		fmt.Fprint(w.progress.out, "\r\033[K")
+		w.progress.drawn = false
+	}
+	n, err := w.out.Write(b)
+	w.progress.drawLocked()
+	return n, err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Markers around the parts of a runbook that are edited by hand, which are
+// kept when the runbook is regenerated
+const (
+	NotesBeginMarker = "<!-- BEGIN USER NOTES %s -->"
+	NotesEndMarker   = "<!-- END USER NOTES -->"
+)
+
+// Placeholder text for notes that have not been written yet
+const (
+	PolicyNotesPlaceholder    = "_Who owns these alerts, and how to escalate._\n"
+	ConditionNotesPlaceholder = "_What this alert means, how to triage it, and who to contact._\n"
+)
+
+var notesPattern = regexp.MustCompile(`(?s)<!-- BEGIN USER NOTES (.+?) -->\n(.*?)<!-- END USER NOTES -->`)
+
+// Notes of an existing runbook by key, in the order they appear
+func parseNotes(text string) (notes map[string]string, keys []string) {
+	notes = make(map[string]string)
+	for _, match := range notesPattern.FindAllStringSubmatch(text, -1) {
+		if _, ok := notes[match[1]]; !ok {
+			keys = append(keys, match[1])
+		}
+		notes[match[1]] = match[2]
+	}
+	return
+}
+
+// A notes block, with the existing notes or a placeholder
+func writeNotes(b *strings.Builder, notes map[string]string, key, placeholder string) {
+	text, ok := notes[key]
+	if !ok {
+		text = placeholder
+	}
+	fmt.Fprintf(b, NotesBeginMarker+"\n%s"+NotesEndMarker+"\n", key, text)
+	delete(notes, key)
+}
+
+// A code fence longer than any run of backticks in the text
+func codeFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+func runbookThreshold(label string, t Threshold) string {
+	if len(t.Operator) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("- %s: %s %s for %ds (%s)\n", label, t.Operator,
+		strconv.FormatFloat(t.Threshold, 'f', -1, 64), t.ThresholdDuration, t.ThresholdOccurrences)
+}
+
+// Markdown runbook of a policy, with a section per condition. Notes from an
+// existing runbook are carried over, including those of removed conditions.
+func (data *LocalData) runbookMarkdown(policy Policy, existing string) string {
+	notes, keys := parseNotes(existing)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", policy.Name)
+	fmt.Fprintf(&b, "Policy %s, incident preference %s.\n", policy.Id, policy.IncidentPreference)
+	b.WriteString("Generated by alerts-tf-scrape, only edit between the USER NOTES markers.\n\n")
+	writeNotes(&b, notes, "policy", PolicyNotesPlaceholder)
+
+	for _, conditionId := range policy.ConditionIds {
+		condition := data.ConditionMap[conditionId]
+		fmt.Fprintf(&b, "\n## %s\n\n", condition.Name)
+		enabled := "yes"
+		if !condition.Enabled {
+			enabled = "no"
+		}
+		fmt.Fprintf(&b, "- Condition: %s, %s\n", condition.Id, condition.Type)
+		fmt.Fprintf(&b, "- Enabled: %s\n", enabled)
+		b.WriteString(runbookThreshold("Critical", condition.Critical))
+		b.WriteString(runbookThreshold("Warning", condition.Warning))
+		fmt.Fprintf(&b, "- Links: [condition builder](%s), [entity](%s)\n",
+			data.Profile.conditionURL(data.NR1URL, condition.Guid, condition.AccountId), PermalinkURL+condition.Guid)
+		if len(condition.RunbookURL) > 0 {
+			fmt.Fprintf(&b, "- Runbook URL: %s\n", condition.RunbookURL)
+		}
+		if len(condition.Query) > 0 {
+			fence := codeFence(condition.Query)
+			fmt.Fprintf(&b, "\n%ssql\n%s\n%s\n", fence, condition.Query, fence)
+		}
+		b.WriteString("\n### Notes\n\n")
+		writeNotes(&b, notes, "condition "+condition.Id, ConditionNotesPlaceholder)
+	}
+
+	// Keep notes written for conditions that have gone
+	var removed []string
+	for _, key := range keys {
+		if text, ok := notes[key]; ok && text != ConditionNotesPlaceholder && text != PolicyNotesPlaceholder {
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) > 0 {
+		b.WriteString("\n## Removed conditions\n\n")
+		b.WriteString("Notes kept from conditions no longer in this policy.\n")
+		for _, key := range removed {
+			fmt.Fprintf(&b, "\n"+NotesBeginMarker+"\n%s"+NotesEndMarker+"\n", key, notes[key])
+		}
+	}
+	return b.String()
+}
+
+// Write a Markdown runbook per policy, keeping the notes of existing ones
+func (data *LocalData) writeRunbooks() (err error) {
+	if err = data.loadSelectors(); err != nil {
+		return
+	}
+	if err = os.MkdirAll(data.RunbookDir, 0755); err != nil {
+		return
+	}
+	var written int
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		filename := filepath.Join(data.RunbookDir, fmt.Sprintf("policy_%s.md", policy.Id))
+		existing, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		text := data.runbookMarkdown(policy, string(existing))
+		if text == string(existing) {
+			continue
+		}
+		if err = os.WriteFile(filename, []byte(text), 0644); err != nil {
+			return err
+		}
+		written++
+	}
+	log.Printf("Wrote %d of %d policy runbooks to %s, the others had no changes", written, len(data.PolicyIds), data.RunbookDir)
+	return
+}
@@ -2,52 +2,115 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
 )
 
+// New Relic pages the scraper visits, changeable for testing against fixtures
+const (
+	DefaultLoginURL  = "https://login.newrelic.com/login"
+	DefaultNR1URL    = "https://one.newrelic.com"
+	DefaultLogoutURL = "https://rpm.newrelic.com/logout"
+)
+
+// A Chrome command line flag, kept as a name and value so the effective
+// launch options can be printed
+type ChromeFlag struct {
+	Name  string
+	Value interface{}
+}
+
+func (flag ChromeFlag) String() string {
+	if b, ok := flag.Value.(bool); ok && b {
+		return "--" + flag.Name
+	}
+	return fmt.Sprintf("--%s=%v", flag.Name, flag.Value)
+}
+
 // For Chrome web driver
-func overrideHeadless() []chromedp.ExecAllocatorOption {
-	return []chromedp.ExecAllocatorOption{
-		chromedp.NoFirstRun,
-		chromedp.NoDefaultBrowserCheck,
-		chromedp.DisableGPU,
+func (data *LocalData) chromeFlags() []ChromeFlag {
+	flags := []ChromeFlag{
+		{"no-first-run", true},
+		{"no-default-browser-check", true},
+		{"disable-gpu", true},
 
 		// After Puppeteer's default behavior.
-		chromedp.Flag("disable-background-networking", true),
-		chromedp.Flag("enable-features", "NetworkService,NetworkServiceInProcess"),
-		chromedp.Flag("disable-background-timer-throttling", true),
-		chromedp.Flag("disable-backgrounding-occluded-windows", true),
-		chromedp.Flag("disable-breakpad", true),
-		chromedp.Flag("disable-client-side-phishing-detection", true),
-		chromedp.Flag("disable-default-apps", true),
-		chromedp.Flag("disable-dev-shm-usage", true),
-		chromedp.Flag("disable-extensions", true),
-		chromedp.Flag("disable-features", "site-per-process,TranslateUI,BlinkGenPropertyTrees"),
-		chromedp.Flag("disable-hang-monitor", true),
-		chromedp.Flag("disable-ipc-flooding-protection", true),
-		chromedp.Flag("disable-popup-blocking", true),
-		chromedp.Flag("disable-prompt-on-repost", true),
-		chromedp.Flag("disable-renderer-backgrounding", true),
-		chromedp.Flag("disable-sync", true),
-		chromedp.Flag("force-color-profile", "srgb"),
-		chromedp.Flag("metrics-recording-only", true),
-		chromedp.Flag("safebrowsing-disable-auto-update", true),
-		chromedp.Flag("enable-automation", true),
-		chromedp.Flag("password-store", "basic"),
-		chromedp.Flag("use-mock-keychain", true),
-	}
-}
-
-func doLogin() chromedp.Tasks {
+		{"disable-background-networking", true},
+		{"enable-features", "NetworkService,NetworkServiceInProcess"},
+		{"disable-background-timer-throttling", true},
+		{"disable-backgrounding-occluded-windows", true},
+		{"disable-breakpad", true},
+		{"disable-client-side-phishing-detection", true},
+		{"disable-default-apps", true},
+		{"disable-dev-shm-usage", true},
+		{"disable-extensions", true},
+		{"disable-features", "site-per-process,TranslateUI,BlinkGenPropertyTrees"},
+		{"disable-hang-monitor", true},
+		{"disable-ipc-flooding-protection", true},
+		{"disable-popup-blocking", true},
+		{"disable-prompt-on-repost", true},
+		{"disable-renderer-backgrounding", true},
+		{"disable-sync", true},
+		{"force-color-profile", "srgb"},
+		{"metrics-recording-only", true},
+		{"safebrowsing-disable-auto-update", true},
+		{"enable-automation", true},
+		{"password-store", "basic"},
+		{"use-mock-keychain", true},
+	}
+	if data.Headless {
+		flags = append(flags, ChromeFlag{"headless", true}, ChromeFlag{"hide-scrollbars", true}, ChromeFlag{"mute-audio", true})
+	}
+	if len(data.UserDataDir) > 0 {
+		flags = append(flags, ChromeFlag{"user-data-dir", data.UserDataDir})
+	}
+	if len(data.Proxy) > 0 {
+		flags = append(flags, ChromeFlag{"proxy-server", data.Proxy})
+	}
+	if data.WindowWidth > 0 {
+		flags = append(flags, ChromeFlag{"window-size", fmt.Sprintf("%d,%d", data.WindowWidth, data.WindowHeight)})
+	}
+	return flags
+}
+
+// Allocator options for launching Chrome, printing the effective settings
+func (data *LocalData) chromeOptions() (opts []chromedp.ExecAllocatorOption) {
+	if len(data.ChromePath) > 0 {
+		log.Printf("Chrome binary: %s", data.ChromePath)
+		opts = append(opts, chromedp.ExecPath(data.ChromePath))
+	} else {
+		log.Printf("Chrome binary: found on the PATH")
+	}
+	var names []string
+	for _, flag := range data.chromeFlags() {
+		opts = append(opts, chromedp.Flag(flag.Name, flag.Value))
+		names = append(names, flag.String())
+	}
+	log.Printf("Chrome flags: %s", strings.Join(names, " "))
+	return
+}
+
+// Parse a window size such as 1280x800
+func parseWindowSize(size string) (width, height int, err error) {
+	_, err = fmt.Sscanf(size, "%dx%d", &width, &height)
+	if err != nil || width <= 0 || height <= 0 || fmt.Sprintf("%dx%d", width, height) != size {
+		return 0, 0, fmt.Errorf("invalid window size %q, expected WIDTHxHEIGHT", size)
+	}
+	return
+}
+
+func (data *LocalData) doLogin() chromedp.Tasks {
 	return chromedp.Tasks{
 		// Navigate to NR ui
-		chromedp.Navigate("https://login.newrelic.com/login"),
+		traced("navigate", "", chromedp.Navigate(data.LoginURL)),
 
 		// Ask for user input
 		chromedp.ActionFunc(func(ctx context.Context) error {
@@ -57,10 +120,10 @@ func doLogin() chromedp.Tasks {
 		}),
 
 		// Wait for login complete
-		chromedp.WaitNotPresent("form#login"),
+		traced("waitNotPresent", "form#login", chromedp.WaitNotPresent("form#login")),
 
 		// Wait for login complete
-		chromedp.WaitVisible("div[id='root']"),
+		traced("wait", "div[id='root']", chromedp.WaitVisible("div[id='root']")),
 
 		// Ask for user input
 		chromedp.ActionFunc(func(ctx context.Context) error {
@@ -72,132 +135,327 @@ func doLogin() chromedp.Tasks {
 }
 
 func (data *LocalData) startChromeAndLogin() (err error) {
+	// Attach to a running Chrome, reusing its logged in session
+	if len(data.RemoteURL) > 0 {
+		log.Printf("Connecting to Chrome at %s", data.RemoteURL)
+		if len(data.ChromePath) > 0 || len(data.UserDataDir) > 0 || len(data.Proxy) > 0 || data.WindowWidth > 0 {
+			log.Printf("Chrome launch options are ignored when attaching with -remote")
+		}
+		var ctx context.Context
+		ctx, data.AllocCancel = chromedp.NewRemoteAllocator(context.Background(), data.RemoteURL)
+		if err = data.openRemoteTab(ctx); err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(data.CDPctx, time.Minute)
+		defer cancel()
+		err = chromedp.Run(ctx, data.checkSession())
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("the Chrome at %s is not logged in to New Relic, please log in there first", data.RemoteURL)
+		}
+		return
+	}
+
 	// Launch scraper
 	log.Println("Launching Chrome web scraper")
-	opts := overrideHeadless()
-	ctx, _ := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, allocCancel := chromedp.NewExecAllocator(context.Background(), data.chromeOptions()...)
+	data.AllocCancel = allocCancel
 	data.CDPctx, data.CDPcancel = chromedp.NewContext(ctx, chromedp.WithLogf(log.Printf))
+	data.CDPctx = data.Tracer.listen(data.CDPctx, "main")
+
+	// Reuse a saved session if there is one
+	if len(data.SessionFile) > 0 {
+		if _, err = os.Stat(data.SessionFile); err == nil {
+			err = data.restoreSession()
+			if err == nil || data.Headless {
+				return
+			}
+			log.Printf("Cannot reuse saved session: %v", err)
+		} else if data.Headless {
+			return fmt.Errorf("no saved session in %s, run once without -headless to log in", data.SessionFile)
+		}
+	} else if data.Headless {
+		return fmt.Errorf("headless mode needs a saved session, set -session")
+	}
 
 	// Do login
-	err = chromedp.Run(data.CDPctx, doLogin())
+	loginCtx, cancel := data.interruptible(data.CDPctx)
+	defer cancel()
+	err = chromedp.Run(loginCtx, data.doLogin())
+	if err == nil && len(data.SessionFile) > 0 {
+		err = data.saveSession()
+	}
+	return
+}
+
+// Connect to an attached Chrome and open a tab of our own for the session
+// check and self-check. The connecting context may be attached to a tab the
+// user has open, so nothing is ever run in it.
+func (data *LocalData) openRemoteTab(allocCtx context.Context) (err error) {
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx, chromedp.WithLogf(log.Printf))
+	data.CDPcancel = browserCancel
+	if err = chromedp.Run(browserCtx); err != nil {
+		return fmt.Errorf("error connecting to Chrome at %s: %v", data.RemoteURL, err)
+	}
+	c := chromedp.FromContext(browserCtx)
+	targetId, err := target.CreateTarget("about:blank").Do(cdp.WithExecutor(browserCtx, c.Browser))
+	if err != nil {
+		return fmt.Errorf("error creating tab: %v", err)
+	}
+	tabCtx, tabCancel := chromedp.NewContext(browserCtx, chromedp.WithTargetID(targetId))
+	data.CDPcancel = func() {
+		tabCancel()
+		browserCancel()
+	}
+	data.CDPctx = data.Tracer.listen(tabCtx, "main")
+	if err = chromedp.Run(data.CDPctx); err != nil {
+		return fmt.Errorf("error attaching to tab: %v", err)
+	}
 	return
 }
 
 func (data *LocalData) logout() {
 	var err error
 
+	// Leave an attached browser logged in
+	if len(data.RemoteURL) > 0 {
+		return
+	}
+
 	// Logout
-	if err = chromedp.Run(data.CDPctx, chromedp.Navigate("https://rpm.newrelic.com/logout")); err != nil {
+	if err = chromedp.Run(data.CDPctx, chromedp.Navigate(data.LogoutURL)); err != nil {
 		log.Println("Login error:", err)
 	}
 }
 
-func (policy *Policy) doScrapeCondition(name, guid string) chromedp.Tasks {
-	var text string
+// Limit how long a single scrape step may take
+func withTimeout(timeout time.Duration, action chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return action.Do(ctx)
+	})
+}
+
+// Scrape a condition's Terraform code with the selected selector profile. The
+// code is taken from the captured API response when possible, and from the
+// rendered code preview otherwise.
+func (data *LocalData) doScrapeCondition(policy Policy, condition Condition, capture *NetCapture, text *string) chromedp.Tasks {
+	var domText string
 	return chromedp.Tasks{
 		// Navigate to alert condition builder page
 		chromedp.ActionFunc(func(ctx context.Context) error {
-			log.Printf("Navigate to condition builder for %q", name)
-			return nil
-		}),
-		chromedp.Navigate(fmt.Sprintf("https://one.newrelic.com/nr1-core/condition-builder/entity/%s?account=%d",
-			guid, policy.AccountId)),
-		chromedp.WaitVisible("div[class*='SelfEnd']>button[type='button']"),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			time.Sleep(50 * time.Millisecond)
-			log.Printf("Click [View as code] button")
+			log.Printf("Navigate to condition builder for %q", condition.Name)
+			capture.reset()
 			return nil
 		}),
-		chromedp.Click("div[class*='SelfEnd']>button[type='button']"),
-		chromedp.WaitVisible("div[class*='StackItem']:first-child>div[role='button']"),
+		traced("navigate", "", withTimeout(data.StepTimeout, chromedp.Navigate(data.Profile.conditionURL(data.NR1URL, condition.Guid, policy.AccountId)))),
 		chromedp.ActionFunc(func(ctx context.Context) error {
-			time.Sleep(50 * time.Millisecond)
-			log.Printf("Click [Terraform] Code preview")
+			err := data.Profile.actions(data.StepTimeout, &domText).Do(ctx)
+			source := "dom"
+			var tf string
+			var ok bool
+			traced("capture", "", chromedp.ActionFunc(func(ctx context.Context) error {
+				tf, ok = capture.terraform(ctx)
+				return nil
+			})).Do(ctx)
+			if ok {
+				*text, source, err = tf, "network", nil
+			} else {
+				*text = domText
+			}
+			if err != nil {
+				return err
+			}
+			if data.DebugSource {
+				log.Printf("Condition %s Terraform copied from %s", condition.Id, source)
+			}
 			return nil
 		}),
-		chromedp.Click("div[class*='StackItem']:first-child>div[role='button']"),
-		chromedp.WaitVisible("div[class*='multiline-code']"),
-		chromedp.Text("div[class*='multiline-code']", &text),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			time.Sleep(50 * time.Millisecond)
-			log.Printf("Copied %d bytes of TF code", len(text))
-			policy.TF += text
+			log.Printf("Copied %d bytes of TF code", len(*text))
 			return nil
 		}),
 	}
 }
 
+// Scrape a condition, reloading and retrying on errors. When all attempts
+// fail, the page is saved for diagnosis.
+func (data *LocalData) scrapeCondition(ctx context.Context, capture *NetCapture, policy Policy, condition Condition) (text string, err error) {
+	ctx = traceCondition(ctx, condition.Id)
+	for attempt := 0; attempt <= data.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retry %d of condition %s after error: %v", attempt, condition.Id, err)
+			if err = chromedp.Run(ctx, traced("reload", "", withTimeout(data.StepTimeout, chromedp.Reload()))); err != nil {
+				log.Printf("Error reloading page for condition %s: %v", condition.Id, err)
+			}
+		}
+		err = chromedp.Run(ctx, data.doScrapeCondition(policy, condition, capture, &text))
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	data.saveDiagnostics(ctx, condition, err)
+	return
+}
+
+// A condition to scrape, and its outcome
+type ScrapeJob struct {
+	PolicyId    int
+	ConditionId int
+}
+type ScrapeResult struct {
+	ScrapeJob
+	Text string
+	Err  error
+}
+
+// Write a policy once all of its conditions are scraped, returning false
+// when re-run conditions could not be spliced in
+func (data *LocalData) finishPolicy(policyId int, texts map[int]string) bool {
+	policy := data.PolicyMap[policyId]
+
+	// Splice re-run conditions into the existing file, or write it all
+	if data.RerunIds != nil {
+		return data.spliceTF(policy, texts)
+	}
+	policy.makeTF(texts)
+	policy.writeTF(".")
+	return true
+}
+
+// The policy and condition maps are all written before the scrapers start,
+// and only read while they run, so the scrapers share them without a lock
 func (data *LocalData) concurrentScrape() {
-	// make channels
-	outputChan := make(chan bool, data.Concurrent)
-	inputChan := make(chan int, len(data.PolicyIds)+data.Concurrent)
+	// Queue conditions, counting how many each policy waits for
+	var jobs []ScrapeJob
+	remaining := make(map[int]int)
+	texts := make(map[int]map[int]string)
+	var resumed int
+	for _, policyId := range data.PolicyIds {
+		if data.Checkpoint.completed(policyId) {
+			continue
+		}
+		texts[policyId] = make(map[int]string)
+		for _, conditionId := range data.PolicyMap[policyId].ConditionIds {
+			if data.RerunIds != nil && !data.RerunIds[conditionId] {
+				continue
+			}
+			if text, ok := data.Checkpoint.text(conditionId); ok {
+				texts[policyId][conditionId] = text
+				resumed++
+				continue
+			}
+			jobs = append(jobs, ScrapeJob{PolicyId: policyId, ConditionId: conditionId})
+			remaining[policyId]++
+		}
+		if remaining[policyId] == 0 && data.finishPolicy(policyId, texts[policyId]) {
+			data.Checkpoint.completePolicy(policyId, data.PolicyMap[policyId].ConditionIds)
+		}
+	}
+	if resumed > 0 {
+		log.Printf("Reusing %d conditions from checkpoint", resumed)
+	}
+	log.Printf("Queued %d conditions to scrape", len(jobs))
+	data.Progress.begin("scrape", len(jobs))
+	data.Progress.setWindows(data.Concurrent)
+	defer data.finishCheckpoint()
 
-	for _, id := range data.PolicyIds {
-		inputChan <- id
+	// make channels
+	outputChan := make(chan ScrapeResult, data.Concurrent)
+	inputChan := make(chan ScrapeJob, len(jobs)+data.Concurrent)
+	for _, job := range jobs {
+		inputChan <- job
 	}
 	for i := 0; i < data.Concurrent; i++ {
-		inputChan <- 0
+		inputChan <- ScrapeJob{}
+	}
+
+	// Open the scraper tabs, in their own windows unless attached to the user's browser
+	pool, err := newTabPool(data.CDPctx, data.Concurrent, len(data.RemoteURL) == 0, data.Tracer)
+	if err != nil {
+		log.Printf("Error opening Chrome tabs: %v", err)
+		return
 	}
+	defer pool.Close()
 
 	// Start concurrent scrapers
 	for i := 1; i <= data.Concurrent; i++ {
-		log.Printf("Opening new Chrome window %d\n", i)
-		var scraperCtx context.Context
-		var scraperCancel context.CancelFunc
 		go func() {
-			var err error
-			var res *runtime.RemoteObject
-			if err = chromedp.Run(data.CDPctx, chromedp.Evaluate(`window.open("about:blank", "", "resizable,scrollbars,status")`, &res)); err != nil {
-				log.Printf("Error opening new Chrome window %d: %v\n", i, err)
-			}
-
-			var targets []*target.Info
-			targets, err = chromedp.Targets(data.CDPctx)
-			if err != nil {
-				log.Printf("Error accessing new Chrome window %d targets: %v\n", i, err)
-			}
-
-			for x, t := range targets {
-				if !t.Attached {
-					scraperCtx, scraperCancel = chromedp.NewContext(data.CDPctx, chromedp.WithTargetID(t.TargetID))
-					fmt.Printf("New Chrome window #%d, target #%d\n", i, x+1)
-					defer scraperCancel()
-					break
-				}
-			}
-
 			for {
-				var policyId int
-				policyId = <-inputChan
-				if policyId == 0 {
+				job := <-inputChan
+				if job.ConditionId == 0 {
 					// exit concurrent
-					outputChan <- true
+					outputChan <- ScrapeResult{}
 					break
 				}
+				policy := data.PolicyMap[job.PolicyId]
+				condition := data.ConditionMap[job.ConditionId]
+				result := ScrapeResult{ScrapeJob: job}
 
-				// Start the TF code with the policy definiton
-				policy := data.PolicyMap[policyId]
-				policy.makePolicyTF()
-
-				// Traverse conditions in order
-				for _, conditionId := range policy.ConditionIds {
-					condition := data.ConditionMap[conditionId]
+				// Once interrupted, abandon the rest of the queue
+				if data.interrupted() {
+					result.Err = ErrInterrupted
+					data.addFailure(policy, condition, result.Err)
+					outputChan <- result
+					continue
+				}
 
-					// Do scrape
-					err = chromedp.Run(scraperCtx, policy.doScrapeCondition(condition.Name, condition.Guid))
-					if err != nil {
-						log.Println("Scrape condition TF error:", err)
-					}
+				// Do scrape
+				tab := pool.Get()
+				data.Progress.busy(1)
+				ctx, cancel := data.interruptible(tab.Ctx)
+				result.Text, result.Err = data.scrapeCondition(ctx, tab.Capture, policy, condition)
+				cancel()
+				if result.Err != nil && data.interrupted() {
+					result.Err = ErrInterrupted
+				}
+				data.Progress.busy(-1)
+				pool.Put(tab, result.Err != nil && result.Err != ErrInterrupted)
+				if result.Err != nil {
+					log.Println("Scrape condition TF error:", result.Err)
+					data.addFailure(policy, condition, result.Err)
 				}
-				data.PolicyMap[policyId] = policy
-				policy.writeTF()
+				outputChan <- result
 			}
 		}()
 	}
 
-	// Complete scrapers
-	for i := 1; i <= data.Concurrent; i++ {
-		<-outputChan
-		log.Printf("Completed scrape on Chrome window %d", i)
+	// Reassemble policies as their conditions complete, leaving out policies
+	// that were cut short by an interrupt. Only policies written with all of
+	// their conditions count as complete in the checkpoint.
+	abandoned := make(map[int]bool)
+	failed := make(map[int]bool)
+	for i := 1; i <= data.Concurrent; {
+		result := <-outputChan
+		if result.ConditionId == 0 {
+			log.Printf("Completed scrape worker %d", i)
+			i++
+			continue
+		}
+		if result.Err == nil {
+			texts[result.PolicyId][result.ConditionId] = result.Text
+			data.Checkpoint.addCondition(result.ConditionId, result.Text)
+			data.Progress.add(1)
+		} else {
+			data.Progress.fail()
+			failed[result.PolicyId] = true
+			abandoned[result.PolicyId] = abandoned[result.PolicyId] || result.Err == ErrInterrupted
+		}
+		remaining[result.PolicyId]--
+		if remaining[result.PolicyId] == 0 {
+			if abandoned[result.PolicyId] {
+				log.Printf("Not writing policy %d, its scrape was interrupted", result.PolicyId)
+			} else {
+				written := data.finishPolicy(result.PolicyId, texts[result.PolicyId])
+				if written && !failed[result.PolicyId] {
+					data.Checkpoint.completePolicy(result.PolicyId, data.PolicyMap[result.PolicyId].ConditionIds)
+				}
+			}
+			delete(texts, result.PolicyId)
+		}
+		data.Checkpoint.save(false)
 	}
+	data.Progress.finish()
+	data.writeFailures()
 }
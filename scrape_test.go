@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Chrome for the browser tests, from CHROME_PATH or the usual names on the PATH
+func findChrome(t *testing.T) string {
+	if path := os.Getenv("CHROME_PATH"); len(path) > 0 {
+		return path
+	}
+	for _, name := range []string{"headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	t.Skip("Chrome not found, set CHROME_PATH to run the browser tests")
+	return ""
+}
+
+// Terraform the fixture API returns for a condition
+func fixtureTF(guid string) string {
+	return fmt.Sprintf("resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = %q\n  enabled = true\n}\n", guid)
+}
+
+// Serve the fixture pages in testdata as the login page, NR1 and the
+// condition builder
+func newFixtureServer(t *testing.T) *httptest.Server {
+	pages := make(map[string][]byte)
+	for _, name := range []string{"login.html", "nr1.html", "condition.html"} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[name] = b
+	}
+	page := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(pages[name])
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", page("login.html"))
+	mux.HandleFunc("/logout", page("login.html"))
+	mux.HandleFunc("/expired", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login?manual=1&return_to=%2Fexpired", http.StatusFound)
+	})
+	mux.HandleFunc("/nr1-core/condition-builder/entity/", page("condition.html"))
+	mux.HandleFunc("/api/terraform/", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Data struct {
+				Terraform string `json:"terraform"`
+			} `json:"data"`
+		}
+		payload.Data.Terraform = fixtureTF(filepath.Base(r.URL.Path))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(payload)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page("nr1.html")(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Settings pointing at the fixture server, with headless Chrome started
+func newFixtureData(t *testing.T, srv *httptest.Server) *LocalData {
+	chrome := findChrome(t)
+	data := &LocalData{
+		Inventory:      Inventory{AccountId: 1},
+		Headless:       true,
+		ChromePath:     chrome,
+		Concurrent:     1,
+		StepTimeout:    5 * time.Second,
+		DiagnosticsDir: t.TempDir(),
+		LoginURL:       srv.URL + "/login",
+		NR1URL:         srv.URL,
+		LogoutURL:      srv.URL + "/logout",
+		Ctx:            context.Background(),
+	}
+	if err := data.loadSelectors(); err != nil {
+		t.Fatal(err)
+	}
+	opts := append(data.chromeOptions(), chromedp.NoSandbox)
+	var ctx context.Context
+	ctx, data.AllocCancel = chromedp.NewExecAllocator(context.Background(), opts...)
+	data.CDPctx, data.CDPcancel = chromedp.NewContext(ctx)
+	t.Cleanup(data.closeChrome)
+	if err := chromedp.Run(data.CDPctx); err != nil {
+		t.Fatalf("starting Chrome: %v", err)
+	}
+	return data
+}
+
+func TestLoginFixture(t *testing.T) {
+	data := newFixtureData(t, newFixtureServer(t))
+	ctx, cancel := context.WithTimeout(data.CDPctx, 30*time.Second)
+	defer cancel()
+	var location string
+	if err := chromedp.Run(ctx, data.doLogin(), chromedp.Location(&location)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location, data.NR1URL+"/") {
+		t.Errorf("after login at %s, want NR1", location)
+	}
+}
+
+func TestCheckSessionFixture(t *testing.T) {
+	data := newFixtureData(t, newFixtureServer(t))
+	ctx, cancel := context.WithTimeout(data.CDPctx, 30*time.Second)
+	defer cancel()
+	if err := chromedp.Run(ctx, data.checkSession()); err != nil {
+		t.Errorf("valid session: %v", err)
+	}
+	data.NR1URL += "/expired"
+	if err := chromedp.Run(ctx, data.checkSession()); err != ErrSessionExpired {
+		t.Errorf("expired session: got %v, want %v", err, ErrSessionExpired)
+	}
+}
+
+func TestScrapeConditionFixture(t *testing.T) {
+	data := newFixtureData(t, newFixtureServer(t))
+	pool, err := newTabPool(data.CDPctx, 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	profileURL := data.Profile.URL
+
+	tests := []struct {
+		name        string
+		query       string
+		stepTimeout time.Duration
+		want        func(guid string) string
+	}{
+		{name: "network", want: fixtureTF},
+		{name: "dom", query: "source=dom", want: func(guid string) string {
+			return fmt.Sprintf("resource \"newrelic_nrql_alert_condition\" \"condition\" {\n  name = %q\n}\n", guid)
+		}},
+		{name: "slow rendering", query: "delay=1500", stepTimeout: 5 * time.Second, want: fixtureTF},
+		{name: "too slow", query: "delay=3000", stepTimeout: time.Second},
+		{name: "missing button", query: "missing=button", stepTimeout: time.Second},
+		{name: "missing code", query: "missing=code", stepTimeout: time.Second},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data.Profile.URL = profileURL + "&" + test.query
+			data.StepTimeout = test.stepTimeout
+			if data.StepTimeout == 0 {
+				data.StepTimeout = 5 * time.Second
+			}
+			policy := Policy{AccountId: 1, Id: "1", Name: "policy"}
+			condition := Condition{AccountId: 1, PolicyId: "1", Id: fmt.Sprint(100 + i), Guid: fmt.Sprintf("guid-%d", i), Name: test.name}
+
+			tab := pool.Get()
+			text, err := data.scrapeCondition(tab.Ctx, tab.Capture, policy, condition)
+			pool.Put(tab, err != nil)
+
+			if test.want == nil {
+				if err == nil {
+					t.Fatalf("got %q, want an error", text)
+				}
+				if _, err := os.Stat(filepath.Join(data.DiagnosticsDir, condition.Id, "error.txt")); err != nil {
+					t.Errorf("diagnostics not saved: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.want(condition.Guid); strings.TrimSpace(text) != strings.TrimSpace(want) {
+				t.Errorf("got %q, want %q", text, want)
+			}
+		})
+	}
+}
+
+func TestConcurrentScrapeFixture(t *testing.T) {
+	data := newFixtureData(t, newFixtureServer(t))
+	data.Concurrent = 3
+	data.Profile.URL += "&delay=300"
+
+	// Two policies of three conditions each, across three tabs
+	data.PolicyMap = make(map[int]Policy)
+	data.ConditionMap = make(map[int]Condition)
+	for policyId := 1; policyId <= 2; policyId++ {
+		policy := Policy{AccountId: 1, Id: fmt.Sprint(policyId), Name: fmt.Sprintf("policy %d", policyId), IncidentPreference: "PER_POLICY"}
+		for i := 1; i <= 3; i++ {
+			conditionId := policyId*10 + i
+			policy.ConditionIds = append(policy.ConditionIds, conditionId)
+			data.ConditionMap[conditionId] = Condition{AccountId: 1, PolicyId: policy.Id, Id: fmt.Sprint(conditionId), Guid: fmt.Sprintf("guid-%d", conditionId), Name: fmt.Sprintf("condition %d", conditionId)}
+		}
+		data.PolicyIds = append(data.PolicyIds, policyId)
+		data.PolicyMap[policyId] = policy
+	}
+
+	// Policy files and the failure report go in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	data.concurrentScrape()
+
+	if len(data.Failures) > 0 {
+		t.Fatalf("failures: %+v", data.Failures)
+	}
+	for _, policyId := range data.PolicyIds {
+		b, err := os.ReadFile(fmt.Sprintf("policy_%d.tf", policyId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tf := string(b)
+		last := -1
+		for _, conditionId := range data.PolicyMap[policyId].ConditionIds {
+			at := strings.Index(tf, tagCondition(fixtureTF(data.ConditionMap[conditionId].Guid), fmt.Sprint(conditionId)))
+			if at < 0 {
+				t.Errorf("policy %d is missing condition %d:\n%s", policyId, conditionId, tf)
+				continue
+			}
+			if at < last {
+				t.Errorf("policy %d has condition %d out of order:\n%s", policyId, conditionId, tf)
+			}
+			last = at
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Built in selector profiles, one per New Relic UI version that has been tested
+//
+//go:embed selectors.json
+var defaultSelectors []byte
+
+// Named profiles of the navigation and click steps that reveal a
+// condition's Terraform code in the NR1 UI
+type SelectorFile struct {
+	Version  int                         `json:"version"`
+	Default  string                      `json:"default"`
+	Profiles map[string]*SelectorProfile `json:"profiles"`
+}
+type SelectorProfile struct {
+	Name        string         `json:"-"`
+	Description string         `json:"description"`
+	URL         string         `json:"url"`
+	Steps       []SelectorStep `json:"steps"`
+}
+type SelectorStep struct {
+	Action   string `json:"action"`
+	Selector string `json:"selector,omitempty"`
+	Millis   int    `json:"millis,omitempty"`
+	Log      string `json:"log,omitempty"`
+}
+
+// Pick the selector profile, with profiles from an optional file overriding
+// the built in ones of the same name
+func (data *LocalData) loadSelectors() (err error) {
+	var selectors SelectorFile
+	if err = json.Unmarshal(defaultSelectors, &selectors); err != nil {
+		return fmt.Errorf("invalid built in selectors: %v", err)
+	}
+	if len(data.SelectorsFile) > 0 {
+		var b []byte
+		var custom SelectorFile
+		if b, err = os.ReadFile(data.SelectorsFile); err != nil {
+			return
+		}
+		if err = json.Unmarshal(b, &custom); err != nil {
+			return fmt.Errorf("invalid selectors file %s: %v", data.SelectorsFile, err)
+		}
+		if custom.Version != selectors.Version {
+			return fmt.Errorf("selectors file %s has version %d, expected %d", data.SelectorsFile, custom.Version, selectors.Version)
+		}
+		for name, profile := range custom.Profiles {
+			selectors.Profiles[name] = profile
+		}
+		if len(custom.Default) > 0 {
+			selectors.Default = custom.Default
+		}
+	}
+
+	name := data.SelectorProfile
+	if len(name) == 0 {
+		name = selectors.Default
+	}
+	profile, ok := selectors.Profiles[name]
+	if !ok {
+		var names []string
+		for name := range selectors.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown selector profile %q, available: %s", name, strings.Join(names, ", "))
+	}
+	profile.Name = name
+	if err = profile.validate(); err != nil {
+		return fmt.Errorf("selector profile %q: %v", name, err)
+	}
+	log.Printf("Using selector profile %q", name)
+	data.Profile = profile
+	return
+}
+
+func (profile *SelectorProfile) validate() error {
+	if !strings.Contains(profile.URL, "{guid}") {
+		return fmt.Errorf("url must contain {guid}")
+	}
+	var text bool
+	for i, step := range profile.Steps {
+		switch step.Action {
+		case "wait", "click":
+		case "text":
+			text = true
+		case "sleep":
+			if step.Millis <= 0 {
+				return fmt.Errorf("step %d: sleep needs millis", i+1)
+			}
+			continue
+		default:
+			return fmt.Errorf("step %d: unknown action %q", i+1, step.Action)
+		}
+		if len(step.Selector) == 0 {
+			return fmt.Errorf("step %d: %s needs a selector", i+1, step.Action)
+		}
+	}
+	if !text {
+		return fmt.Errorf("no text step to copy the Terraform code")
+	}
+	return nil
+}
+
+// Condition builder page for a condition, under the NR1 base URL
+func (profile *SelectorProfile) conditionURL(nr1URL, guid string, accountId int) string {
+	return strings.NewReplacer(
+		"{nr1}", strings.TrimSuffix(nr1URL, "/"),
+		"{guid}", guid,
+		"{account}", strconv.Itoa(accountId),
+	).Replace(profile.URL)
+}
+
+// Chromedp actions for the profile steps, copying the code into text
+func (profile *SelectorProfile) actions(timeout time.Duration, text *string) (tasks chromedp.Tasks) {
+	for _, step := range profile.Steps {
+		step := step
+		if len(step.Log) > 0 {
+			tasks = append(tasks, chromedp.ActionFunc(func(ctx context.Context) error {
+				time.Sleep(50 * time.Millisecond)
+				log.Print(step.Log)
+				return nil
+			}))
+		}
+		switch step.Action {
+		case "wait":
+			tasks = append(tasks, traced(step.Action, step.Selector, withTimeout(timeout, chromedp.WaitVisible(step.Selector))))
+		case "click":
+			tasks = append(tasks, traced(step.Action, step.Selector, withTimeout(timeout, chromedp.Click(step.Selector))))
+		case "sleep":
+			tasks = append(tasks, traced(step.Action, "", chromedp.Sleep(time.Duration(step.Millis)*time.Millisecond)))
+		case "text":
+			tasks = append(tasks, traced(step.Action, step.Selector, withTimeout(timeout, chromedp.Text(step.Selector, text))))
+		}
+	}
+	return
+}
+
+// Hash of the selected profile and its steps, to tell whether a checkpoint
+// was self-checked with the same selectors
+func (profile *SelectorProfile) hash() string {
+	b, _ := json.Marshal(profile)
+	return fmt.Sprintf("%x", sha256.Sum256(append([]byte(profile.Name+"\n"), b...)))
+}
+
+// Wait before the first retry of the self-check, doubling for each one after
+const SelfCheckBackoff = 2 * time.Second
+
+// Check the selected profile on the first condition before a full run,
+// retrying with backoff so one slow page load does not abort the run. A
+// resumed run skips it when its checkpoint passed with the same profile.
+func (data *LocalData) selfCheck() (err error) {
+	profileHash := data.Profile.hash()
+	if data.Checkpoint.selfChecked(profileHash) {
+		log.Printf("Skipping self-check, selector profile %q already passed in %s", data.Profile.Name, data.Checkpoint.filename)
+		return nil
+	}
+	capture := listenNetwork(data.CDPctx)
+	for _, policyId := range data.PolicyIds {
+		policy := data.PolicyMap[policyId]
+		for _, conditionId := range policy.ConditionIds {
+			if data.RerunIds != nil && !data.RerunIds[conditionId] {
+				continue
+			}
+			condition := data.ConditionMap[conditionId]
+			log.Printf("Self-check of selector profile %q on condition %s", data.Profile.Name, condition.Id)
+			ctx, cancel := data.interruptible(data.CDPctx)
+			defer cancel()
+			for attempt := 0; attempt <= data.Retries; attempt++ {
+				if attempt > 0 {
+					wait := SelfCheckBackoff << (attempt - 1)
+					log.Printf("Retry %d of self-check in %s after error: %v", attempt, wait, err)
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(wait):
+					}
+				}
+				var text string
+				err = chromedp.Run(ctx, data.doScrapeCondition(policy, condition, capture, &text))
+				if err == nil && !strings.Contains(text, "resource") {
+					err = fmt.Errorf("copied text is not Terraform: %.80q", text)
+				}
+				if err == nil || ctx.Err() != nil {
+					break
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("selector profile %q failed on condition %s: %v", data.Profile.Name, condition.Id, err)
+			}
+			log.Printf("Self-check passed")
+			data.Checkpoint.passSelfCheck(profileHash)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+var ErrSessionExpired = errors.New("saved New Relic session has expired, run again without -headless to log in")
+
+// Save the browser cookies after an interactive login
+func (data *LocalData) saveSession() (err error) {
+	var cookies []*network.Cookie
+	err = chromedp.Run(data.CDPctx, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		cookies, err = storage.GetCookies().Do(ctx)
+		return
+	}))
+	if err != nil {
+		return
+	}
+	b, err := json.MarshalIndent(cookies, "", "  ")
+	if err != nil {
+		return
+	}
+	log.Printf("Saving %d session cookies to %s", len(cookies), data.SessionFile)
+	return os.WriteFile(data.SessionFile, b, 0600)
+}
+
+// Load saved cookies into the browser and check they are still logged in
+func (data *LocalData) restoreSession() (err error) {
+	b, err := os.ReadFile(data.SessionFile)
+	if err != nil {
+		return
+	}
+	var cookies []*network.Cookie
+	if err = json.Unmarshal(b, &cookies); err != nil {
+		return fmt.Errorf("invalid session file %s: %v", data.SessionFile, err)
+	}
+	var params []*network.CookieParam
+	now := float64(time.Now().Unix())
+	for _, c := range cookies {
+		if !c.Session && c.Expires > 0 && c.Expires < now {
+			continue
+		}
+		param := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			SameSite: c.SameSite,
+		}
+		if !c.Session {
+			expires := cdp.TimeSinceEpoch(time.Unix(int64(c.Expires), 0))
+			param.Expires = &expires
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		return ErrSessionExpired
+	}
+	log.Printf("Restoring %d session cookies from %s", len(params), data.SessionFile)
+	if err = chromedp.Run(data.CDPctx, storage.SetCookies(params)); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(data.CDPctx, time.Minute)
+	defer cancel()
+	err = chromedp.Run(ctx, data.checkSession())
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrSessionExpired
+	}
+	return
+}
+
+// Whether the browser was sent to log in. Any page on the login host counts,
+// unless it is also the NR1 host, as when testing against fixtures.
+func (data *LocalData) onLoginPage(location string) bool {
+	login, err := url.Parse(data.LoginURL)
+	if err != nil {
+		return strings.HasPrefix(location, data.LoginURL)
+	}
+	nr1, err := url.Parse(data.NR1URL)
+	if err == nil && nr1.Host == login.Host {
+		return strings.HasPrefix(location, data.LoginURL)
+	}
+	return strings.HasPrefix(location, login.Scheme+"://"+login.Host+"/")
+}
+
+// A valid session lands on the NR1 UI, an expired one on the login page
+func (data *LocalData) checkSession() chromedp.Tasks {
+	var location string
+	return chromedp.Tasks{
+		chromedp.Navigate(data.NR1URL),
+		chromedp.Sleep(2 * time.Second),
+		chromedp.Location(&location),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if data.onLoginPage(location) {
+				return ErrSessionExpired
+			}
+			return nil
+		}),
+		chromedp.WaitVisible("div[id='root']"),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			log.Println("Login complete, using saved session")
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var ErrInterrupted = errors.New("interrupted")
+
+// Cancel data.Ctx on the first Ctrl-C so the scrape can wind down and clean
+// up, and restore the default handling so a second Ctrl-C quits at once
+func (data *LocalData) handleSignals() {
+	var stop context.CancelFunc
+	data.Ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-data.Ctx.Done()
+		stop()
+		log.Println("Interrupted, writing completed policies and shutting down Chrome (Ctrl-C again to quit now)")
+	}()
+}
+
+func (data *LocalData) interrupted() bool {
+	return data.Ctx.Err() != nil
+}
+
+// Derive a context from ctx that is also cancelled on interrupt
+func (data *LocalData) interruptible(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-data.Ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Close our tabs and shut down Chrome, or just disconnect from an attached one
+func (data *LocalData) closeChrome() {
+	if data.CDPcancel != nil {
+		data.CDPcancel()
+	}
+	if data.AllocCancel != nil {
+		data.AllocCancel()
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// A browser tab created and owned by the scraper
+type Tab struct {
+	Id      int
+	Ctx     context.Context
+	Capture *NetCapture
+	cancel  context.CancelFunc
+	lock    sync.Mutex
+	crashed bool
+}
+
+// Pool of scraper tabs. Each tab is handed to one worker at a time, and a
+// tab that crashed is closed and replaced by a new one.
+type TabPool struct {
+	parent    context.Context
+	newWindow bool
+	tracer    *Tracer
+	tabs      chan *Tab
+	lock      sync.Mutex
+	open      map[int]*Tab
+	lastId    int
+}
+
+func newTabPool(parent context.Context, size int, newWindow bool, tracer *Tracer) (pool *TabPool, err error) {
+	pool = &TabPool{
+		parent:    parent,
+		newWindow: newWindow,
+		tracer:    tracer,
+		tabs:      make(chan *Tab, size),
+		open:      make(map[int]*Tab),
+	}
+	for i := 0; i < size; i++ {
+		var tab *Tab
+		if tab, err = pool.newTab(); err != nil {
+			pool.Close()
+			return
+		}
+		pool.tabs <- tab
+	}
+	return
+}
+
+// Create a target explicitly and attach a context that owns it, so that
+// cancelling the context closes the tab
+func (pool *TabPool) newTab() (tab *Tab, err error) {
+	c := chromedp.FromContext(pool.parent)
+	if c == nil || c.Browser == nil {
+		return nil, fmt.Errorf("browser is not running")
+	}
+	var targetId target.ID
+	targetId, err = target.CreateTarget("about:blank").WithNewWindow(pool.newWindow).Do(cdp.WithExecutor(pool.parent, c.Browser))
+	if err != nil {
+		return nil, fmt.Errorf("error creating tab: %v", err)
+	}
+
+	pool.lock.Lock()
+	pool.lastId++
+	tab = &Tab{Id: pool.lastId}
+	pool.open[tab.Id] = tab
+	pool.lock.Unlock()
+
+	tab.Ctx, tab.cancel = chromedp.NewContext(pool.parent, chromedp.WithTargetID(targetId))
+	tab.Capture = listenNetwork(tab.Ctx)
+	tab.Ctx = pool.tracer.listen(tab.Ctx, strconv.Itoa(tab.Id))
+	chromedp.ListenTarget(tab.Ctx, func(ev interface{}) {
+		switch ev.(type) {
+		case *inspector.EventTargetCrashed, *inspector.EventDetached:
+			tab.lock.Lock()
+			tab.crashed = true
+			tab.lock.Unlock()
+		}
+	})
+	if err = chromedp.Run(tab.Ctx); err != nil {
+		pool.closeTab(tab)
+		return nil, fmt.Errorf("error attaching to tab: %v", err)
+	}
+	log.Printf("Opened Chrome tab %d", tab.Id)
+	return
+}
+
+func (pool *TabPool) closeTab(tab *Tab) {
+	tab.cancel()
+	pool.lock.Lock()
+	delete(pool.open, tab.Id)
+	pool.lock.Unlock()
+}
+
+// Wait for a free tab
+func (pool *TabPool) Get() *Tab {
+	return <-pool.tabs
+}
+
+// Return a tab to the pool, replacing it if it no longer responds. If no
+// replacement can be opened the dead tab goes back, so later jobs fail fast
+// and the next return tries again.
+func (pool *TabPool) Put(tab *Tab, failed bool) {
+	if failed && !tab.alive() {
+		log.Printf("Chrome tab %d crashed, replacing it", tab.Id)
+		pool.closeTab(tab)
+		for attempt := 1; attempt <= 3; attempt++ {
+			replacement, err := pool.newTab()
+			if err == nil {
+				tab = replacement
+				break
+			}
+			log.Printf("Error replacing Chrome tab: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+	pool.tabs <- tab
+}
+
+func (tab *Tab) alive() bool {
+	tab.lock.Lock()
+	crashed := tab.crashed
+	tab.lock.Unlock()
+	if crashed || tab.Ctx.Err() != nil {
+		return false
+	}
+	var result int
+	ctx, cancel := context.WithTimeout(tab.Ctx, 5*time.Second)
+	defer cancel()
+	return chromedp.Run(ctx, chromedp.Evaluate(`1`, &result)) == nil
+}
+
+// Close every tab the pool opened
+func (pool *TabPool) Close() {
+	pool.lock.Lock()
+	var tabs []*Tab
+	for _, tab := range pool.open {
+		tabs = append(tabs, tab)
+	}
+	pool.lock.Unlock()
+	for _, tab := range tabs {
+		pool.closeTab(tab)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Terraform resource types managed by this tool
+const (
+	TFPolicyType    = "newrelic_alert_policy"
+	TFConditionType = "newrelic_nrql_alert_condition"
+)
+
+// Alert resources and literal values read from a directory of Terraform files
+type TFConfig struct {
+	Policies   []*TFResource
+	Conditions []*TFResource
+	Imports    map[string]string
+	Variables  map[string]string
+	Locals     map[string]string
+}
+type TFResource struct {
+	Type   string
+	Name   string
+	File   string
+	Item   *TFItem
+	LiveId int
+}
+
+// Terraform address of a resource, e.g. newrelic_alert_policy.foo
+func (r *TFResource) Address() string {
+	return r.Type + "." + r.Name
+}
+
+// Read all .tf files below dir, skipping provider caches
+func loadTFDir(dir string) (config *TFConfig, err error) {
+	config = &TFConfig{
+		Imports:   make(map[string]string),
+		Variables: make(map[string]string),
+		Locals:    make(map[string]string),
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".tf" {
+			return nil
+		}
+		return config.loadFile(path)
+	})
+	return
+}
+
+func (config *TFConfig) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	body, err := parseTF(string(b))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, item := range body.Items {
+		if item.Body == nil {
+			continue
+		}
+		switch item.Key {
+		case "resource":
+			if len(item.Labels) != 2 {
+				continue
+			}
+			r := &TFResource{Type: item.Labels[0], Name: item.Labels[1], File: path, Item: item}
+			switch r.Type {
+			case TFPolicyType:
+				config.Policies = append(config.Policies, r)
+			case TFConditionType:
+				config.Conditions = append(config.Conditions, r)
+			}
+		case "import":
+			config.Imports[item.Attr("to")] = item.Attr("id")
+		case "variable":
+			if len(item.Labels) == 1 {
+				config.Variables[item.Labels[0]] = item.Attr("default")
+			}
+		case "locals":
+			for _, local := range item.Body.Items {
+				if local.Body == nil {
+					config.Locals[local.Key] = local.Value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve an expression to a literal value, following variable defaults and
+// locals. References to other resources and computed values are not resolved.
+func (config *TFConfig) resolve(expr string) (value string, ok bool) {
+	return config.resolveDepth(expr, 0)
+}
+
+func (config *TFConfig) resolveDepth(expr string, depth int) (value string, ok bool) {
+	expr = strings.TrimSpace(expr)
+	if depth > 10 || len(expr) == 0 {
+		return
+	}
+	switch {
+	case strings.HasPrefix(expr, `"`):
+		// $${ and %%{ are escapes for a literal ${ and %{, anything else is a template
+		unescaped := strings.NewReplacer("$${", "", "%%{", "").Replace(expr)
+		if strings.Contains(unescaped, "${") || strings.Contains(unescaped, "%{") {
+			return
+		}
+		value, err := strconv.Unquote(expr)
+		return strings.NewReplacer("$${", "${", "%%{", "%{").Replace(value), err == nil
+	case strings.HasPrefix(expr, "<<"):
+		return heredocValue(expr)
+	case strings.HasPrefix(expr, "var."):
+		def, found := config.Variables[strings.TrimPrefix(expr, "var.")]
+		if !found {
+			return
+		}
+		return config.resolveDepth(def, depth+1)
+	case strings.HasPrefix(expr, "local."):
+		def, found := config.Locals[strings.TrimPrefix(expr, "local.")]
+		if !found {
+			return
+		}
+		return config.resolveDepth(def, depth+1)
+	case expr == "true" || expr == "false" || expr == "null":
+		return expr, true
+	}
+	if _, err := strconv.ParseFloat(expr, 64); err == nil {
+		return expr, true
+	}
+	return
+}
+
+// Content of a heredoc, with indentation removed for the <<- form
+func heredocValue(expr string) (value string, ok bool) {
+	nl := strings.IndexByte(expr, '\n')
+	if nl < 0 {
+		return
+	}
+	header := strings.TrimSpace(expr[2:nl])
+	indented := strings.HasPrefix(header, "-")
+	marker := strings.TrimPrefix(header, "-")
+	lines := strings.Split(expr[nl+1:], "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[len(lines)-1]) != marker {
+		return
+	}
+	lines = lines[:len(lines)-1]
+	if indented {
+		indent := -1
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			n := len(line) - len(strings.TrimLeft(line, " \t"))
+			if indent < 0 || n < indent {
+				indent = n
+			}
+		}
+		for i, line := range lines {
+			if len(line) >= indent && indent > 0 {
+				lines[i] = line[indent:]
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// Resolve an attribute of a resource to a literal value
+func (config *TFConfig) attr(r *TFResource, key string) (string, bool) {
+	return config.resolve(r.Item.Attr(key))
+}
+
+// Live id from an import block for this resource. Policies import by id and
+// conditions by policy_id:condition_id:type.
+func (config *TFConfig) importedId(r *TFResource) (id int, ok bool) {
+	importId, found := config.Imports[r.Address()]
+	if !found {
+		return
+	}
+	importId, found = config.resolve(importId)
+	if !found {
+		return
+	}
+	parts := strings.Split(importId, ":")
+	part := parts[0]
+	if r.Type == TFConditionType {
+		if len(parts) < 2 {
+			return
+		}
+		part = parts[1]
+	}
+	id, err := strconv.Atoi(part)
+	return id, err == nil
+}
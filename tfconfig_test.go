@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	config := &TFConfig{
+		Variables: map[string]string{"name": `"CPU"`, "alias": "local.threshold", "loop": "var.loop"},
+		Locals:    map[string]string{"threshold": "90.5", "prefix": `"${var.name}"`},
+	}
+	for _, test := range []struct {
+		expr  string
+		value string
+		ok    bool
+	}{
+		{`"plain"`, "plain", true},
+		{`"quote \" and \n newline"`, "quote \" and \n newline", true},
+		{`"$${literal} and %%{directive}"`, "${literal} and %{directive}", true},
+		{`"${var.name}"`, "", false},
+		{`"%{ if true }x%{ endif }"`, "", false},
+		{`"$${ok} ${var.name}"`, "", false},
+		{"<<EOT\nline one\n  line two\nEOT", "line one\n  line two", true},
+		{"<<-EOT\n    line one\n      line two\n    EOT", "line one\n  line two", true},
+		{"<<EOT\nunterminated\n", "", false},
+		{"var.name", "CPU", true},
+		{"var.alias", "90.5", true},
+		{"local.prefix", "", false},
+		{"var.missing", "", false},
+		{"var.loop", "", false},
+		{"true", "true", true},
+		{"null", "null", true},
+		{"-1e3", "-1e3", true},
+		{"newrelic_alert_policy.foo.id", "", false},
+		{"", "", false},
+	} {
+		value, ok := config.resolve(test.expr)
+		if value != test.value || ok != test.ok {
+			t.Errorf("resolve(%q) = %q, %v, want %q, %v", test.expr, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestLoadTFDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.tf": `variable "policy_id" {
+  default = "123"
+}
+locals {
+  name = "CPU $${high}"
+}
+resource "newrelic_alert_policy" "policy" {
+  name = "one"
+}
+import {
+  to = newrelic_nrql_alert_condition.cpu
+  id = "123:456:static"
+}
+`,
+		"conditions/cpu.tf": `resource "newrelic_nrql_alert_condition" "cpu" {
+  policy_id = var.policy_id
+  name = local.name
+}
+`,
+		".terraform/modules/ignored.tf": `resource "newrelic_alert_policy" "ignored" {}`,
+		"notes.txt":                     `resource "newrelic_alert_policy" "ignored" {}`,
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := loadTFDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Policies) != 1 || len(config.Conditions) != 1 {
+		t.Fatalf("got %d policies and %d conditions, want 1 and 1", len(config.Policies), len(config.Conditions))
+	}
+	condition := config.Conditions[0]
+	if name, ok := config.attr(condition, "name"); !ok || name != "CPU ${high}" {
+		t.Errorf("name = %q, %v", name, ok)
+	}
+	if policyId, ok := config.attr(condition, "policy_id"); !ok || policyId != "123" {
+		t.Errorf("policy_id = %q, %v", policyId, ok)
+	}
+	if id, ok := config.importedId(condition); !ok || id != 456 {
+		t.Errorf("imported id = %d, %v, want 456", id, ok)
+	}
+	if _, ok := config.importedId(config.Policies[0]); ok {
+		t.Error("policy without an import block has an imported id")
+	}
+}
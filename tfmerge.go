@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resource types the scraper writes. Existing resources of these types that
+// are no longer generated are removed, unless they are between user edit
+// markers.
+var generatedKinds = map[string]bool{
+	"resource." + TFPolicyType:    true,
+	"resource." + TFConditionType: true,
+}
+
+// Terraform meta-arguments, which are never generated and so are always kept
+var metaArguments = map[string]bool{
+	"count": true, "depends_on": true, "for_each": true, "lifecycle": true, "provider": true,
+}
+
+// A replacement of src[start:end], or an insertion when start == end
+type tfEdit struct {
+	start, end int
+	text       string
+}
+
+// Apply edits to src. Edits must not overlap, since the result of applying
+// both would depend on their order.
+func applyEdits(src string, edits []tfEdit) (string, error) {
+	var b strings.Builder
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	last := 0
+	for _, e := range edits {
+		if e.start < last || e.end < e.start || e.end > len(src) {
+			return "", fmt.Errorf("overlapping edits at offset %d", e.start)
+		}
+		b.WriteString(src[last:e.start])
+		b.WriteString(e.text)
+		last = e.end
+	}
+	b.WriteString(src[last:])
+	return b.String(), nil
+}
+
+// Text of an item, always ending with a newline
+func itemText(src string, item *TFItem) string {
+	text := src[item.Start:item.End]
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
+// Kind of a top level item, such as resource.newrelic_alert_policy
+func (item *TFItem) kind() string {
+	if item.Body != nil && len(item.Labels) > 1 {
+		return item.Key + "." + item.Labels[0]
+	}
+	return item.Key
+}
+
+// Pair each generated item with an existing item of the same identity. Several
+// items can share an identity, such as scraped conditions that are all
+// labelled "condition", so those are paired by condition tag first, then by
+// name and then in order. Items tagged with different conditions never pair.
+func pairItems(oldSrc string, old []*TFItem, genSrc string, gen []*TFItem) map[*TFItem]*TFItem {
+	byIdentity := make(map[string][]*TFItem)
+	for _, item := range old {
+		byIdentity[item.identity()] = append(byIdentity[item.identity()], item)
+	}
+	pairs := make(map[*TFItem]*TFItem)
+	used := make(map[*TFItem]bool)
+	for pass := 0; pass < 3; pass++ {
+		for _, g := range gen {
+			if _, ok := pairs[g]; ok {
+				continue
+			}
+			genTag := conditionTagOf(genSrc, g)
+			for _, o := range byIdentity[g.identity()] {
+				oldTag := conditionTagOf(oldSrc, o)
+				if used[o] || len(genTag) > 0 && len(oldTag) > 0 && genTag != oldTag {
+					continue
+				}
+				if pass == 0 && (len(genTag) == 0 || genTag != oldTag) {
+					continue
+				}
+				if pass == 1 && (len(g.Attr("name")) == 0 || o.Attr("name") != g.Attr("name")) {
+					continue
+				}
+				pairs[g] = o
+				used[o] = true
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// Merge freshly generated Terraform into an existing file. Resources are
+// matched by type and name, generated attributes and blocks are updated in
+// place, and generated resources and blocks that are no longer produced are
+// removed. Everything else (comments, resources of other types,
+// meta-arguments, and anything between user edit markers) is kept as is, as
+// are the blocks of conditions tagged with an id in keep.
+func mergeTF(existing, generated string, keep map[string]bool) (merged string, err error) {
+	var old, gen *TFBody
+	if old, err = parseTF(existing); err != nil {
+		return
+	}
+	if gen, err = parseTF(generated); err != nil {
+		return
+	}
+
+	var oldItems []*TFItem
+	userItems := make(map[string]bool)
+	for _, item := range old.Items {
+		if item.User {
+			userItems[item.identity()] = true
+		} else {
+			oldItems = append(oldItems, item)
+		}
+	}
+	var genItems []*TFItem
+	kinds := make(map[string]bool)
+	for _, item := range gen.Items {
+		kinds[item.kind()] = true
+		if !userItems[item.identity()] {
+			genItems = append(genItems, item)
+		}
+	}
+	pairs := pairItems(existing, oldItems, generated, genItems)
+
+	var edits []tfEdit
+	var added []string
+	matched := make(map[*TFItem]bool)
+	for _, item := range genItems {
+		o, ok := pairs[item]
+		if !ok {
+			added = append(added, itemText(generated, item))
+			continue
+		}
+		matched[o] = true
+		var text string
+		if text, err = mergeItem(existing, o, generated, item); err != nil {
+			return
+		}
+		text = tagCondition(text, conditionTagOf(generated, item))
+		edits = append(edits, tfEdit{o.Start, o.End, text})
+	}
+
+	// Remove generated resources that are gone, with the blank line after them,
+	// but keep conditions still in the policy that just failed to generate
+	for _, item := range oldItems {
+		if matched[item] || !generatedKinds[item.kind()] && !kinds[item.kind()] || keep[conditionTagOf(existing, item)] {
+			continue
+		}
+		end := item.End
+		if strings.HasPrefix(existing[end:], "\n") {
+			end++
+		}
+		edits = append(edits, tfEdit{item.Start, end, ""})
+	}
+
+	if merged, err = applyEdits(existing, edits); err != nil {
+		return
+	}
+	for _, text := range added {
+		if len(merged) > 0 && !strings.HasSuffix(merged, "\n\n") {
+			if !strings.HasSuffix(merged, "\n") {
+				merged += "\n"
+			}
+			merged += "\n"
+		}
+		merged += text
+	}
+	return
+}
+
+// Merge one generated item into its existing counterpart
+func mergeItem(oldSrc string, o *TFItem, genSrc string, g *TFItem) (string, error) {
+	if o.Body == nil || g.Body == nil {
+		return itemText(genSrc, g), nil
+	}
+
+	userKeys := make(map[string]bool)
+	var oldKeys []string
+	oldByKey := make(map[string][]*TFItem)
+	for _, item := range o.Body.Items {
+		if item.User {
+			userKeys[item.Key] = true
+			continue
+		}
+		if _, ok := oldByKey[item.Key]; !ok {
+			oldKeys = append(oldKeys, item.Key)
+		}
+		oldByKey[item.Key] = append(oldByKey[item.Key], item)
+	}
+	var genKeys []string
+	genByKey := make(map[string][]*TFItem)
+	for _, item := range g.Body.Items {
+		if _, ok := genByKey[item.Key]; !ok {
+			genKeys = append(genKeys, item.Key)
+		}
+		genByKey[item.Key] = append(genByKey[item.Key], item)
+	}
+
+	// New items go on their own line just before the closing brace
+	insertAt := o.Body.End
+	insertPrefix := "\n"
+	lineStart := strings.LastIndexByte(oldSrc[:o.Body.End], '\n') + 1
+	if lineStart > o.Body.Start && strings.TrimSpace(oldSrc[lineStart:o.Body.End]) == "" {
+		insertAt = lineStart
+		insertPrefix = ""
+	}
+
+	var edits []tfEdit
+	for _, key := range genKeys {
+		if userKeys[key] {
+			continue
+		}
+		genItems, oldItems := genByKey[key], oldByKey[key]
+		var text string
+		if len(genItems) == 1 && len(oldItems) == 1 && genItems[0].Body != nil && oldItems[0].Body != nil &&
+			strings.Contains(oldSrc[oldItems[0].Body.Start:oldItems[0].Body.End], "\n") {
+			var err error
+			if text, err = mergeItem(oldSrc, oldItems[0], genSrc, genItems[0]); err != nil {
+				return "", err
+			}
+		} else {
+			for _, item := range genItems {
+				text += itemText(genSrc, item)
+			}
+		}
+		if len(oldItems) == 0 {
+			edits = append(edits, tfEdit{insertAt - o.Start, insertAt - o.Start, insertPrefix + text})
+			insertPrefix = ""
+			continue
+		}
+		edits = append(edits, tfEdit{oldItems[0].Start - o.Start, oldItems[0].End - o.Start, text})
+		for _, item := range oldItems[1:] {
+			edits = append(edits, tfEdit{item.Start - o.Start, item.End - o.Start, ""})
+		}
+	}
+
+	// Remove generated attributes and blocks that are no longer produced
+	for _, key := range oldKeys {
+		if _, ok := genByKey[key]; ok || metaArguments[key] {
+			continue
+		}
+		for _, item := range oldByKey[key] {
+			edits = append(edits, tfEdit{item.Start - o.Start, item.End - o.Start, ""})
+		}
+	}
+	return applyEdits(oldSrc[o.Start:o.End], edits)
+}
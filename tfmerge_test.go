@@ -0,0 +1,230 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testMerge(t *testing.T, existing, generated, want string) {
+	t.Helper()
+	got, err := mergeTF(existing, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Merging again must not change anything
+	again, err := mergeTF(got, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != got {
+		t.Errorf("second merge:\n%s\nwant:\n%s", again, got)
+	}
+}
+
+func TestMergeTFKeepsHandEdits(t *testing.T) {
+	existing := `# Owned by the platform team
+resource "newrelic_nrql_alert_condition" "cpu" {
+  name = "CPU"
+  description = <<-EOT
+    Old text
+    EOT
+  lifecycle {
+    ignore_changes = [enabled]
+  }
+  # BEGIN USER EDITS
+  runbook_url = "https://example.com/cpu"
+  # END USER EDITS
+}
+
+resource "newrelic_notification_channel" "email" {
+  name = "email"
+}
+`
+	generated := `resource "newrelic_nrql_alert_condition" "cpu" {
+  name = "CPU"
+  description = <<-EOT
+    New text
+    EOT
+  runbook_url = "https://example.com/generated"
+}
+`
+	want := `# Owned by the platform team
+resource "newrelic_nrql_alert_condition" "cpu" {
+  name = "CPU"
+  description = <<-EOT
+    New text
+    EOT
+  lifecycle {
+    ignore_changes = [enabled]
+  }
+  # BEGIN USER EDITS
+  runbook_url = "https://example.com/cpu"
+  # END USER EDITS
+}
+
+resource "newrelic_notification_channel" "email" {
+  name = "email"
+}
+`
+	testMerge(t, existing, generated, want)
+}
+
+func TestMergeTFUserResource(t *testing.T) {
+	existing := `# BEGIN USER EDITS
+resource "newrelic_nrql_alert_condition" "cpu" {
+  name = "CPU"
+  enabled = false
+}
+# END USER EDITS
+`
+	generated := `resource "newrelic_nrql_alert_condition" "cpu" {
+  name = "CPU"
+  enabled = true
+}
+`
+	testMerge(t, existing, generated, existing)
+}
+
+func TestMergeTFDuplicateIdentities(t *testing.T) {
+	existing := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "First"
+  enabled = true
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Second"
+  enabled = true
+  lifecycle {
+    prevent_destroy = true
+  }
+}
+`
+	generated := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "First"
+  enabled = false
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Second"
+  enabled = false
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Third"
+  enabled = true
+}
+`
+	want := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "First"
+  enabled = false
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Second"
+  enabled = false
+  lifecycle {
+    prevent_destroy = true
+  }
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Third"
+  enabled = true
+}
+`
+	testMerge(t, existing, generated, want)
+}
+
+func TestMergeTFDuplicateNames(t *testing.T) {
+	existing := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Same"
+  enabled = true
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Same"
+  enabled = true
+}
+`
+	generated := strings.ReplaceAll(existing, "true", "false")
+	testMerge(t, existing, generated, generated)
+}
+
+func TestMergeTFRemoved(t *testing.T) {
+	existing := `resource "newrelic_alert_policy" "policy_1" {
+  name = "one"
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Deleted"
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Kept"
+  critical {
+    operator = "above"
+    threshold = 90
+  }
+  warning {
+    operator = "above"
+    threshold = 80
+  }
+  fill_value = 0
+}
+
+# BEGIN USER EDITS
+resource "newrelic_nrql_alert_condition" "extra" {
+  name = "Extra"
+}
+# END USER EDITS
+`
+	generated := `resource "newrelic_alert_policy" "policy_1" {
+  name = "one"
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Kept"
+  critical {
+    operator = "above"
+    threshold = 95
+  }
+}
+`
+	want := `resource "newrelic_alert_policy" "policy_1" {
+  name = "one"
+}
+
+resource "newrelic_nrql_alert_condition" "condition" {
+  name = "Kept"
+  critical {
+    operator = "above"
+    threshold = 95
+  }
+}
+
+# BEGIN USER EDITS
+resource "newrelic_nrql_alert_condition" "extra" {
+  name = "Extra"
+}
+# END USER EDITS
+`
+	testMerge(t, existing, generated, want)
+}
+
+func TestApplyEditsOverlap(t *testing.T) {
+	src := "0123456789"
+	got, err := applyEdits(src, []tfEdit{{6, 8, "x"}, {1, 3, "ab"}, {5, 5, "-"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "0ab34-5x89" {
+		t.Errorf("applyEdits = %q", got)
+	}
+	if _, err := applyEdits(src, []tfEdit{{1, 5, ""}, {3, 7, ""}}); err == nil {
+		t.Error("overlapping edits did not fail")
+	}
+}
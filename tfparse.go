@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Comments marking a region of hand edits that regeneration must never touch
+const (
+	UserBeginMarker = "BEGIN USER EDITS"
+	UserEndMarker   = "END USER EDITS"
+)
+
+// Terraform source read as blocks and attributes. Only the HCL structure is
+// parsed, expressions are kept as raw text, and every item remembers its byte
+// offsets so that generated code can be spliced into hand-edited files.
+type TFBody struct {
+	Items      []*TFItem
+	Start, End int
+}
+type TFItem struct {
+	Key        string
+	Labels     []string
+	Value      string
+	Body       *TFBody
+	Start, End int
+	User       bool
+}
+
+type tfParser struct {
+	src  string
+	pos  int
+	user bool
+}
+
+// Parse Terraform source into its top level items
+func parseTF(src string) (body *TFBody, err error) {
+	p := &tfParser{src: src}
+	body, err = p.parseBody(true)
+	return
+}
+
+// Identity of a top level item, used to match generated and existing blocks
+func (item *TFItem) identity() string {
+	if item.Body == nil {
+		return item.Key
+	}
+	if item.Key == "import" {
+		return "import." + item.Attr("to")
+	}
+	return strings.Join(append([]string{item.Key}, item.Labels...), ".")
+}
+
+// Raw expression of an attribute in a block body
+func (item *TFItem) Attr(key string) string {
+	if item.Body == nil {
+		return ""
+	}
+	for _, child := range item.Body.Items {
+		if child.Body == nil && child.Key == key {
+			return child.Value
+		}
+	}
+	return ""
+}
+
+// First nested block of the given type
+func (item *TFItem) Block(key string) *TFItem {
+	if item.Body == nil {
+		return nil
+	}
+	for _, child := range item.Body.Items {
+		if child.Body != nil && child.Key == key {
+			return child
+		}
+	}
+	return nil
+}
+
+func (p *tfParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *tfParser) parseBody(top bool) (body *TFBody, err error) {
+	body = &TFBody{Start: p.pos}
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			if !top {
+				err = p.errorf("unexpected end of file, missing }")
+				return
+			}
+			body.End = p.pos
+			return
+		}
+		if p.skipComment() {
+			continue
+		}
+		if p.src[p.pos] == '}' {
+			if top {
+				err = p.errorf("unexpected }")
+				return
+			}
+			body.End = p.pos
+			return
+		}
+
+		// Items own their indentation when they start a line
+		item := &TFItem{Start: p.pos, User: p.user}
+		lineStart := strings.LastIndexByte(p.src[:p.pos], '\n') + 1
+		if strings.TrimSpace(p.src[lineStart:p.pos]) == "" {
+			item.Start = lineStart
+		}
+		item.Key = p.ident()
+		if len(item.Key) == 0 {
+			err = p.errorf("unexpected character %q", p.src[p.pos])
+			return
+		}
+		p.skipSpace(false)
+		if p.pos < len(p.src) && p.src[p.pos] == '=' {
+			p.pos++
+			valueStart := p.pos
+			if err = p.skipExpression(); err != nil {
+				return
+			}
+			item.Value = strings.TrimSpace(p.src[valueStart:p.pos])
+		} else {
+			for {
+				p.skipSpace(false)
+				if p.pos >= len(p.src) {
+					err = p.errorf("unexpected end of file in block %s", item.Key)
+					return
+				}
+				if p.src[p.pos] == '{' {
+					break
+				}
+				if p.src[p.pos] == '"' {
+					start := p.pos
+					if err = p.skipString(); err != nil {
+						return
+					}
+					label, _ := strconv.Unquote(p.src[start:p.pos])
+					item.Labels = append(item.Labels, label)
+					continue
+				}
+				label := p.ident()
+				if len(label) == 0 {
+					err = p.errorf("unexpected character %q in block %s", p.src[p.pos], item.Key)
+					return
+				}
+				item.Labels = append(item.Labels, label)
+			}
+			p.pos++
+			if item.Body, err = p.parseBody(false); err != nil {
+				return
+			}
+			p.pos++
+		}
+		p.skipLineEnd()
+		item.End = p.pos
+		body.Items = append(body.Items, item)
+	}
+}
+
+func (p *tfParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *tfParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\r' || newlines && c == '\n' {
+			p.pos++
+			continue
+		}
+		break
+	}
+}
+
+// Skip a comment, tracking the user edit markers
+func (p *tfParser) skipComment() bool {
+	rest := p.src[p.pos:]
+	var text string
+	switch {
+	case strings.HasPrefix(rest, "#"), strings.HasPrefix(rest, "//"):
+		end := strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		text = strings.TrimLeft(rest[:end], "#/")
+		p.pos += end
+	case strings.HasPrefix(rest, "/*"):
+		end := strings.Index(rest, "*/")
+		if end < 0 {
+			end = len(rest)
+		} else {
+			end += 2
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(rest[:end], "/*"), "*/")
+		p.pos += end
+	default:
+		return false
+	}
+	switch strings.ToUpper(strings.TrimSpace(text)) {
+	case UserBeginMarker:
+		p.user = true
+	case UserEndMarker:
+		p.user = false
+	}
+	return true
+}
+
+// Consume trailing spaces, an optional comment and the newline after an item
+func (p *tfParser) skipLineEnd() {
+	p.skipSpace(false)
+	if p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '}' {
+		p.skipComment()
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+		p.pos++
+	}
+}
+
+// Skip an attribute expression, which ends at a newline or closing brace
+// outside of any brackets, strings or heredocs
+func (p *tfParser) skipExpression() error {
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			if err := p.skipString(); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(p.src[p.pos:], "<<"):
+			if err := p.skipHeredoc(); err != nil {
+				return err
+			}
+			continue
+		case c == '#' || strings.HasPrefix(p.src[p.pos:], "//") || strings.HasPrefix(p.src[p.pos:], "/*"):
+			if depth == 0 {
+				return nil
+			}
+			p.skipComment()
+			continue
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		case c == '\n':
+			if depth == 0 {
+				return nil
+			}
+		}
+		p.pos++
+	}
+	if depth > 0 {
+		return p.errorf("unexpected end of file in expression")
+	}
+	return nil
+}
+
+// Skip a quoted string including any ${} template interpolations, but not
+// the $${ and %%{ escapes
+func (p *tfParser) skipString() error {
+	p.pos++
+	for p.pos < len(p.src) {
+		switch {
+		case p.src[p.pos] == '\\':
+			p.pos += 2
+		case p.src[p.pos] == '"':
+			p.pos++
+			return nil
+		case strings.HasPrefix(p.src[p.pos:], "$${"), strings.HasPrefix(p.src[p.pos:], "%%{"):
+			p.pos += 3
+		case strings.HasPrefix(p.src[p.pos:], "${"), strings.HasPrefix(p.src[p.pos:], "%{"):
+			p.pos += 2
+			depth := 1
+			for p.pos < len(p.src) && depth > 0 {
+				switch p.src[p.pos] {
+				case '"':
+					if err := p.skipString(); err != nil {
+						return err
+					}
+					continue
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+				p.pos++
+			}
+		case p.src[p.pos] == '\n':
+			return p.errorf("unterminated string")
+		default:
+			p.pos++
+		}
+	}
+	return p.errorf("unterminated string")
+}
+
+func (p *tfParser) skipHeredoc() error {
+	start := p.pos
+	p.pos += 2
+	if p.pos < len(p.src) && p.src[p.pos] == '-' {
+		p.pos++
+	}
+	marker := p.ident()
+	if len(marker) == 0 {
+		p.pos = start + 2
+		return nil
+	}
+	for p.pos < len(p.src) {
+		next := strings.IndexByte(p.src[p.pos:], '\n')
+		if next < 0 {
+			break
+		}
+		p.pos += next + 1
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		if strings.TrimSpace(p.src[p.pos:p.pos+end]) == marker {
+			p.pos += end
+			return nil
+		}
+	}
+	p.pos = start
+	return p.errorf("unterminated heredoc %s", marker)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTFHeredoc(t *testing.T) {
+	src := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "CPU"
+  description = <<-EOT
+    A } brace and a "quote"
+    EOT
+  enabled = true
+}
+`
+	body, err := parseTF(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(body.Items))
+	}
+	item := body.Items[0]
+	if got := item.identity(); got != "resource.newrelic_nrql_alert_condition.condition" {
+		t.Errorf("identity = %q", got)
+	}
+	if got := item.Attr("description"); got != "<<-EOT\n    A } brace and a \"quote\"\n    EOT" {
+		t.Errorf("description = %q", got)
+	}
+	if got := item.Attr("enabled"); got != "true" {
+		t.Errorf("enabled = %q", got)
+	}
+	if item.Start != 0 || item.End != len(src) {
+		t.Errorf("item spans %d-%d, want 0-%d", item.Start, item.End, len(src))
+	}
+}
+
+func TestParseTFUserMarkers(t *testing.T) {
+	src := `# BEGIN USER EDITS
+resource "newrelic_alert_policy" "mine" {
+  name = "mine"
+}
+# END USER EDITS
+resource "newrelic_alert_policy" "policy_1" {
+  name = "one"
+  // begin user edits
+  enabled = false
+  // end user edits
+  incident_preference = "PER_POLICY"
+}
+`
+	body, err := parseTF(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(body.Items))
+	}
+	if !body.Items[0].User || body.Items[1].User {
+		t.Errorf("user flags = %v, %v, want true, false", body.Items[0].User, body.Items[1].User)
+	}
+	var user []string
+	for _, item := range body.Items[1].Body.Items {
+		if item.User {
+			user = append(user, item.Key)
+		}
+	}
+	if len(user) != 1 || user[0] != "enabled" {
+		t.Errorf("user attributes = %v, want [enabled]", user)
+	}
+}
+
+func TestParseTFErrors(t *testing.T) {
+	for _, src := range []string{
+		"resource \"a\" \"b\" {\n  name = \"x\"\n",
+		"resource \"a\" \"b\" {\n  name = \"x\n}\n",
+		"resource \"a\" \"b\" {\n  description = <<EOT\n  text\n}\n",
+		"}\n",
+	} {
+		if _, err := parseTF(src); err == nil {
+			t.Errorf("parseTF(%q) did not fail", src)
+		}
+	}
+}
+
+func TestParseTFTemplates(t *testing.T) {
+	src := `resource "newrelic_nrql_alert_condition" "condition" {
+  name = "${var.prefix} {CPU}"
+  escaped = "$${not} %%{a template"
+  nested = "${join(", ", ["}", "{"])}"
+  directive = "%{ if var.on }on%{ endif }"
+  enabled = true
+}
+`
+	body, err := parseTF(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := body.Items[0]
+	for key, want := range map[string]string{
+		"name":      `"${var.prefix} {CPU}"`,
+		"escaped":   `"$${not} %%{a template"`,
+		"nested":    `"${join(", ", ["}", "{"])}"`,
+		"directive": `"%{ if var.on }on%{ endif }"`,
+		"enabled":   "true",
+	} {
+		if got := item.Attr(key); got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestParseTFComments(t *testing.T) {
+	src := `/* A block comment with a } brace
+   over two lines */
+resource "newrelic_alert_policy" "policy_1" { # trailing comment
+  name = "one" // the name
+  # incident_preference = "PER_POLICY"
+  /* enabled = { */
+  url = "https://example.com/#anchor"
+}
+// last
+`
+	body, err := parseTF(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 {
+		t.Fatalf("got %d items, want 1", len(body.Items))
+	}
+	var keys []string
+	for _, item := range body.Items[0].Body.Items {
+		keys = append(keys, item.Key+"="+item.Value)
+	}
+	want := []string{`name="one"`, `url="https://example.com/#anchor"`}
+	if len(keys) != len(want) || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("attributes = %q, want %q", keys, want)
+	}
+}
+
+func TestParseTFNestedLists(t *testing.T) {
+	src := `resource "newrelic_nrql_alert_condition" "condition" {
+  tags = {
+    team = ["a", "b"]
+    env  = "prod"
+  }
+  lifecycle {
+    ignore_changes = [
+      enabled,
+      # a comment in a list
+      description,
+    ]
+  }
+  critical {
+    operator = "above"
+  }
+  critical {
+    operator = "below"
+  }
+}
+`
+	body, err := parseTF(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := body.Items[0]
+	if got := item.Attr("tags"); got != "{\n    team = [\"a\", \"b\"]\n    env  = \"prod\"\n  }" {
+		t.Errorf("tags = %q", got)
+	}
+	lifecycle := item.Block("lifecycle")
+	if lifecycle == nil {
+		t.Fatal("no lifecycle block")
+	}
+	if got := lifecycle.Attr("ignore_changes"); !strings.HasPrefix(got, "[") || !strings.HasSuffix(got, "]") || !strings.Contains(got, "description,") {
+		t.Errorf("ignore_changes = %q", got)
+	}
+	if got := item.Block("critical").Attr("operator"); got != `"above"` {
+		t.Errorf("first critical operator = %s", got)
+	}
+	if n := len(item.Body.Items); n != 4 {
+		t.Errorf("got %d items in the resource, want 4", n)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Header and query parameter names whose values never go into a trace
+var redactedNames = []string{"cookie", "authorization", "api-key", "apikey", "token", "password", "secret", "session", "code", "state", "key"}
+
+// Headers whose values are URLs, which get their query parameters redacted
+var urlHeaders = map[string]bool{"referer": true, "location": true, "origin": true, "content-location": true}
+
+const Redacted = "[redacted]"
+
+// Records a HAR of network activity and a timeline of scrape steps per tab,
+// for attaching to a bug report
+type Tracer struct {
+	lock     sync.Mutex
+	tabs     []string
+	entries  map[string][]*HAREntry
+	pending  map[string]map[network.RequestID]*pendingRequest
+	timeline []TraceEvent
+}
+type TraceEvent struct {
+	Tab        string    `json:"tab"`
+	Condition  string    `json:"condition,omitempty"`
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Selector   string    `json:"selector,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+}
+type pendingRequest struct {
+	entry     *HAREntry
+	monotonic time.Time
+}
+
+// The subset of HAR 1.2 that Chrome events can fill in
+type HARFile struct {
+	Log struct {
+		Version string      `json:"version"`
+		Creator HARNameOnly `json:"creator"`
+		Entries []*HAREntry `json:"entries"`
+	} `json:"log"`
+}
+type HARNameOnly struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	Cookies     []HARNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+type HARResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Content     struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int    `json:"bodySize"`
+}
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newTracer() *Tracer {
+	return &Tracer{
+		entries: make(map[string][]*HAREntry),
+		pending: make(map[string]map[network.RequestID]*pendingRequest),
+	}
+}
+
+func redacted(name string) bool {
+	name = strings.ToLower(name)
+	for _, r := range redactedNames {
+		if strings.Contains(name, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func harHeaders(headers network.Headers) (values []HARNameValue) {
+	values = []HARNameValue{}
+	for name, value := range headers {
+		text := fmt.Sprint(value)
+		if redacted(name) {
+			text = Redacted
+		} else if urlHeaders[strings.ToLower(name)] {
+			text, _ = harURL(text)
+		}
+		values = append(values, HARNameValue{Name: name, Value: text})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+	return
+}
+
+// Redact sensitive query parameters from a URL
+func harURL(raw string) (clean string, query []HARNameValue) {
+	query = []HARNameValue{}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw, query
+	}
+	u.User = nil
+	params := u.Query()
+	for name, values := range params {
+		for i := range values {
+			if redacted(name) {
+				values[i] = Redacted
+			}
+			query = append(query, HARNameValue{Name: name, Value: values[i]})
+		}
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), query
+}
+
+// Add a tab to the trace, recording its network activity under the label
+func (tracer *Tracer) listen(ctx context.Context, tab string) context.Context {
+	if tracer == nil {
+		return ctx
+	}
+	tracer.lock.Lock()
+	tracer.tabs = append(tracer.tabs, tab)
+	tracer.pending[tab] = make(map[network.RequestID]*pendingRequest)
+	tracer.lock.Unlock()
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		tracer.lock.Lock()
+		defer tracer.lock.Unlock()
+		pending := tracer.pending[tab]
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			entry := &HAREntry{}
+			if ev.WallTime != nil {
+				entry.StartedDateTime = ev.WallTime.Time()
+			}
+			entry.Request.Method = ev.Request.Method
+			entry.Request.URL, entry.Request.QueryString = harURL(ev.Request.URL)
+			entry.Request.HTTPVersion = "HTTP/1.1"
+			entry.Request.Headers = harHeaders(ev.Request.Headers)
+			entry.Request.Cookies = []HARNameValue{}
+			entry.Request.HeadersSize = -1
+			entry.Request.BodySize = len(ev.Request.PostData)
+			if ev.Request.HasPostData {
+				entry.Comment = "request body removed"
+			}
+			entry.Response.Headers = []HARNameValue{}
+			entry.Response.Cookies = []HARNameValue{}
+			entry.Response.HeadersSize = -1
+			entry.Response.BodySize = -1
+			request := &pendingRequest{entry: entry}
+			if ev.Timestamp != nil {
+				request.monotonic = ev.Timestamp.Time()
+			}
+			pending[ev.RequestID] = request
+			tracer.entries[tab] = append(tracer.entries[tab], entry)
+		case *network.EventResponseReceived:
+			request, ok := pending[ev.RequestID]
+			if !ok || ev.Response == nil {
+				return
+			}
+			response := &request.entry.Response
+			response.Status = ev.Response.Status
+			response.StatusText = ev.Response.StatusText
+			response.HTTPVersion = ev.Response.Protocol
+			response.Headers = harHeaders(ev.Response.Headers)
+			response.Content.MimeType = ev.Response.MimeType
+			if ev.Timestamp != nil && !request.monotonic.IsZero() {
+				request.entry.Timings.Wait = float64(ev.Timestamp.Time().Sub(request.monotonic)) / float64(time.Millisecond)
+			}
+		case *network.EventLoadingFinished:
+			request, ok := pending[ev.RequestID]
+			if !ok {
+				return
+			}
+			request.entry.Response.BodySize = int(ev.EncodedDataLength)
+			request.entry.Response.Content.Size = int(ev.EncodedDataLength)
+			if ev.Timestamp != nil && !request.monotonic.IsZero() {
+				request.entry.Time = float64(ev.Timestamp.Time().Sub(request.monotonic)) / float64(time.Millisecond)
+				request.entry.Timings.Receive = request.entry.Time - request.entry.Timings.Wait
+			}
+			delete(pending, ev.RequestID)
+		case *network.EventLoadingFailed:
+			request, ok := pending[ev.RequestID]
+			if !ok {
+				return
+			}
+			request.entry.Comment = strings.TrimSpace(request.entry.Comment + " failed: " + ev.ErrorText)
+			delete(pending, ev.RequestID)
+		}
+	})
+	return context.WithValue(ctx, traceKey{}, &traceTab{tracer: tracer, tab: tab})
+}
+
+// Trace details carried in a tab's context
+type traceKey struct{}
+type traceTab struct {
+	tracer    *Tracer
+	tab       string
+	condition string
+}
+
+// Label timeline events in ctx with the condition being scraped
+func traceCondition(ctx context.Context, conditionId string) context.Context {
+	t, ok := ctx.Value(traceKey{}).(*traceTab)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, &traceTab{tracer: t.tracer, tab: t.tab, condition: conditionId})
+}
+
+// Record an action in the timeline of its tab, when tracing
+func traced(name, selector string, action chromedp.Action) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		t, ok := ctx.Value(traceKey{}).(*traceTab)
+		if !ok {
+			return action.Do(ctx)
+		}
+		start := time.Now()
+		err := action.Do(ctx)
+		event := TraceEvent{
+			Tab:        t.tab,
+			Condition:  t.condition,
+			Time:       start,
+			Action:     name,
+			Selector:   selector,
+			DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		t.tracer.lock.Lock()
+		t.tracer.timeline = append(t.tracer.timeline, event)
+		t.tracer.lock.Unlock()
+		return err
+	})
+}
+
+// Write the timeline and one HAR per tab into a zip file
+func (tracer *Tracer) write(filename string) {
+	if tracer == nil {
+		return
+	}
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Printf("Error creating trace bundle: %v", err)
+		return
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	add := func(name string, v interface{}) {
+		w, err := z.Create(name)
+		if err != nil {
+			log.Printf("Error adding %s to trace bundle: %v", name, err)
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(v); err != nil {
+			log.Printf("Error adding %s to trace bundle: %v", name, err)
+		}
+	}
+	add("timeline.json", tracer.timeline)
+	for _, tab := range tracer.tabs {
+		var har HARFile
+		har.Log.Version = "1.2"
+		har.Log.Creator = HARNameOnly{Name: "alerts-tf-scrape", Version: "1"}
+		har.Log.Entries = tracer.entries[tab]
+		if har.Log.Entries == nil {
+			har.Log.Entries = []*HAREntry{}
+		}
+		add(fmt.Sprintf("tab-%s.har", tab), har)
+	}
+	if err = z.Close(); err != nil {
+		log.Printf("Error writing trace bundle: %v", err)
+		return
+	}
+	log.Printf("Wrote trace of %d tabs to %s", len(tracer.tabs), filename)
+}